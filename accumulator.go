@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Streaming aggregation: instead of one AggregateSigs over every signature at block-cut time, the orderer folds each
+// transaction's aggregate endorsement into a running sum as it arrives. Finalising a block then costs the orderer's
+// own Sign plus a single addition. Signatures are group elements so an evicted transaction is removed by adding its negation.
+
+// Order r of the BLS12-381 groups. Multiplying by r-1 negates an element (the bindings have no negation of their own).
+const bls12381OrderMinusOne = "73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000000"
+
+var (
+	minusOneOnce sync.Once
+	minusOne     *blschia.PrivateKey
+)
+
+// NegateSignature returns -sig so that sig.Add(NegateSignature(sig)) is the identity
+func NegateSignature(sig *blschia.G2Element) *blschia.G2Element {
+	minusOneOnce.Do(func() {
+		bytes, _ := hex.DecodeString(bls12381OrderMinusOne)
+		var err error
+		if minusOne, err = blschia.PrivateKeyFromBytes(bytes, false); err != nil {
+			panic(err)
+		}
+	})
+	return sig.Mul(minusOne)
+}
+
+// SignatureAccumulator is a running aggregate of signatures. It is not safe for concurrent use.
+type SignatureAccumulator struct {
+	sum   *blschia.G2Element
+	count int
+}
+
+func (a *SignatureAccumulator) Add(sig *blschia.G2Element) {
+	if a.sum == nil {
+		a.sum = sig
+	} else {
+		a.sum = a.sum.Add(sig)
+	}
+	a.count++
+}
+
+// Remove subtracts a signature previously added (nothing to subtract from an empty accumulator)
+func (a *SignatureAccumulator) Remove(sig *blschia.G2Element) {
+	if a.sum == nil || a.count == 0 {
+		return
+	}
+	a.sum = a.sum.Add(NegateSignature(sig))
+	a.count--
+}
+
+// Count is the number of signatures currently in the aggregate
+func (a *SignatureAccumulator) Count() int {
+	return a.count
+}
+
+// Sum returns the current aggregate (nil when nothing was ever added)
+func (a *SignatureAccumulator) Sum() *blschia.G2Element {
+	return a.sum
+}
+
+// Finalise returns the aggregate with one more signature added, leaving the accumulator untouched
+func (a *SignatureAccumulator) Finalise(sig *blschia.G2Element) *blschia.G2Element {
+	if a.sum == nil || a.count == 0 {
+		return sig
+	}
+	return a.sum.Add(sig)
+}
+
+func (a *SignatureAccumulator) Reset() {
+	a.sum = nil
+	a.count = 0
+}
+
+// SignAccumulated is Block.Sign when the endorsements of the block have already been accumulated
+func (b *Block) SignAccumulated(scheme SignatureScheme, ordererSk *blschia.PrivateKey, endorsements *SignatureAccumulator) {
+	b.OrdererSignature = scheme.Sign(ordererSk, b.Header.Bytes())
+	b.AggregateSignature = endorsements.Finalise(b.OrdererSignature)
+}
+
+// Accumulating endorsements as they arrive, evicting one and checking the result against aggregation at cut time
+func StreamingAggregationExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+
+	var accumulator SignatureAccumulator
+	var txs []*Transaction
+	for i := 0; i < 5; i++ {
+		proposal, _ := makeRandomArray(5000)
+		tx := exampleEndorse(scheme, config, sks, proposal)
+		accumulator.Add(tx.Endorsement)
+		txs = append(txs, tx)
+	}
+	// Say the third transaction expired while waiting for the block
+	accumulator.Remove(txs[2].Endorsement)
+	txs = append(txs[:2], txs[3:]...)
+
+	streamed := NewBlock(nil, txs)
+	streamed.SignAccumulated(scheme, orderer_sk, &accumulator)
+	if !config.VerifyBlock(scheme, streamed) {
+		panic("failed a verification of the streamed block signature")
+	}
+	batched := NewBlock(nil, txs)
+	batched.Sign(scheme, orderer_sk)
+	if !streamed.AggregateSignature.EqualTo(batched.AggregateSignature) {
+		panic("streamed and batched block signatures differ")
+	}
+
+	// The orderer does the same internally when a queued transaction is evicted. The block is cut by count once the
+	// remaining transactions are in, the timeout only guards against a block that never fills.
+	orderer := NewOrderer(scheme, config, orderer_sk, OrdererConfig{MaxTransactions: len(txs) - 1, BatchTimeout: time.Second})
+	_, update := orderer.Blocks(0)
+	orderer.Submit(txs[0])
+	orderer.Submit(txs[1])
+	if !orderer.Evict(txs[1].Proposal) {
+		panic("orderer did not find the transaction to evict")
+	}
+	for _, tx := range txs[2:] {
+		orderer.Submit(tx)
+	}
+	<-update
+	blocks, _ := orderer.Blocks(0)
+	if len(blocks[0].Transactions) != len(txs)-1 || !config.VerifyBlock(scheme, blocks[0]) {
+		panic("failed a verification of the block after eviction")
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"testing"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
 )
 
 // Run <env vars...> go test -bench=.
@@ -22,3 +25,294 @@ func BenchmarkOurProposalPopExample(b *testing.B) {
 		OurProposalPopExample()
 	}
 }
+
+func BenchmarkDigestProposalAugExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		DigestProposalAugExample()
+	}
+}
+
+func BenchmarkMerkleBlockExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		MerkleBlockExample()
+	}
+}
+
+func BenchmarkLightClientExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		LightClientExample()
+	}
+}
+
+func BenchmarkCheckpointExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		CheckpointExample()
+	}
+}
+
+func BenchmarkNetworkSimulationExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		NetworkSimulationExample()
+	}
+}
+
+func BenchmarkGRPCExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		GRPCExample()
+	}
+}
+
+func BenchmarkWireExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		WireExample()
+	}
+}
+
+func BenchmarkOrdererExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		OrdererExample()
+	}
+}
+
+func BenchmarkStreamingAggregationExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		StreamingAggregationExample()
+	}
+}
+
+func BenchmarkRaftOrderingExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		RaftOrderingExample()
+	}
+}
+
+func BenchmarkBFTOrderingExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		BFTOrderingExample()
+	}
+}
+
+func BenchmarkGossipExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		GossipExample()
+	}
+}
+
+func BenchmarkWorldStateExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		WorldStateExample()
+	}
+}
+
+func BenchmarkUPIPaymentExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		UPIPaymentExample()
+	}
+}
+
+func BenchmarkWorkloadExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		WorkloadExample()
+	}
+}
+
+func BenchmarkReplayProtectionExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		ReplayProtectionExample()
+	}
+}
+
+func BenchmarkMultiChannelExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		MultiChannelExample()
+	}
+}
+
+func BenchmarkConfigTransactionExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		ConfigTransactionExample()
+	}
+}
+
+func BenchmarkRevocationExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		RevocationExample()
+	}
+}
+
+func BenchmarkCertificateIdentityExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		CertificateIdentityExample()
+	}
+}
+
+func BenchmarkFabricFormatExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		FabricFormatExample()
+	}
+}
+
+func BenchmarkFabricPluginExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		FabricPluginExample()
+	}
+}
+
+func BenchmarkCodecExample(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		CodecExample()
+	}
+}
+
+var benchmarkPayloadSizes = []int{32, 256, 1024, 5000, 16384, 65536}
+
+func benchmarkKey(b *testing.B, scheme blschia.Scheme) (*blschia.PrivateKey, *blschia.G1Element) {
+	seed, _ := makeRandomArray(32)
+	sk, err := scheme.KeyGen(seed)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pk, _ := sk.G1Element()
+	return sk, pk
+}
+
+// Raw payload signing vs digest signing across payload sizes
+func BenchmarkSignRawPayload(b *testing.B) {
+	scheme := blschia.NewAugSchemeMPL()
+	sk, _ := benchmarkKey(b, scheme)
+	for _, size := range benchmarkPayloadSizes {
+		payload, _ := makeRandomArray(size)
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				scheme.Sign(sk, payload)
+			}
+		})
+	}
+}
+
+func BenchmarkSignDigest(b *testing.B) {
+	for _, alg := range []DigestAlgorithm{DigestSHA256, DigestSHA512} {
+		scheme := NewDigestScheme(blschia.NewAugSchemeMPL(), alg)
+		sk, _ := benchmarkKey(b, scheme.Scheme)
+		for _, size := range benchmarkPayloadSizes {
+			payload, _ := makeRandomArray(size)
+			b.Run(fmt.Sprintf("%s/%d", alg, size), func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					scheme.Sign(sk, payload)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkVerifyRawPayload(b *testing.B) {
+	scheme := blschia.NewAugSchemeMPL()
+	sk, pk := benchmarkKey(b, scheme)
+	for _, size := range benchmarkPayloadSizes {
+		payload, _ := makeRandomArray(size)
+		sig := scheme.Sign(sk, payload)
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				scheme.Verify(pk, payload, sig)
+			}
+		})
+	}
+}
+
+func BenchmarkVerifyDigest(b *testing.B) {
+	for _, alg := range []DigestAlgorithm{DigestSHA256, DigestSHA512} {
+		scheme := NewDigestScheme(blschia.NewAugSchemeMPL(), alg)
+		sk, pk := benchmarkKey(b, scheme.Scheme)
+		for _, size := range benchmarkPayloadSizes {
+			payload, _ := makeRandomArray(size)
+			sig := scheme.Sign(sk, payload)
+			b.Run(fmt.Sprintf("%s/%d", alg, size), func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					scheme.Verify(pk, payload, sig)
+				}
+			})
+		}
+	}
+}
+
+var benchmarkCheckpointBlocks = []int{1, 4, 16}
+
+// Verifying a range of blocks one by one vs verifying their checkpoint
+func BenchmarkPerBlockVerify(b *testing.B) {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	for _, count := range benchmarkCheckpointBlocks {
+		blocks := exampleChain(scheme, config, sks, orderer_sk, count, 2)
+		b.Run(fmt.Sprintf("%d", count), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				for _, block := range blocks {
+					if !config.VerifyBlock(scheme, block) {
+						b.Fatal("block verification failed")
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCheckpointVerify(b *testing.B) {
+	for _, scheme := range []SignatureScheme{blschia.NewAugSchemeMPL(), blschia.NewPopSchemeMPL()} {
+		config, sks, orderer_sk := exampleChannel(scheme.(blschia.Scheme))
+		for _, count := range benchmarkCheckpointBlocks {
+			blocks := exampleChain(scheme, config, sks, orderer_sk, count, 2)
+			checkpoint, err := BuildCheckpoint(scheme, config, blocks)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%T/%d", scheme, count), func(b *testing.B) {
+				b.ReportMetric(float64(checkpoint.Size()), "checkpoint-bytes")
+				for n := 0; n < b.N; n++ {
+					if err := checkpoint.Verify(scheme, config); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Peer verification cost of a block by number of transactions (to tune OrdererConfig.MaxTransactions)
+func BenchmarkBlockVerifyBySize(b *testing.B) {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	for _, size := range []int{1, 2, 5, 10, 50} {
+		block := exampleChain(scheme, config, sks, orderer_sk, 1, size)[0]
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if !config.VerifyBlock(scheme, block) {
+					b.Fatal("block verification failed")
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*size), "ns/tx")
+		})
+	}
+}
+
+// Block finalisation with aggregation at cut time vs with endorsements accumulated as transactions arrived
+func BenchmarkBlockFinaliseBatched(b *testing.B) {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	block := exampleChain(scheme, config, sks, orderer_sk, 1, 50)[0]
+	for n := 0; n < b.N; n++ {
+		block.Sign(scheme, orderer_sk)
+	}
+}
+
+func BenchmarkBlockFinaliseStreamed(b *testing.B) {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	block := exampleChain(scheme, config, sks, orderer_sk, 1, 50)[0]
+	var accumulator SignatureAccumulator
+	for _, tx := range block.Transactions {
+		accumulator.Add(tx.Endorsement)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		block.SignAccumulated(scheme, orderer_sk, &accumulator)
+	}
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// BFT ordering among the orderer organisations, a PBFT/Tendermint style protocol with locking. Every block number is
+// decided in one or more views, each led by the next consenter in turn:
+//   - the leader proposes an unsigned block, or the block a consenter is locked on along with the certificate that locked it,
+//   - consenters check it and send a prepare vote, a signature over the view and the header hash,
+//   - a quorum of prepare votes aggregates into a prepare certificate, on which a consenter locks the block and sends a
+//     commit vote, a signature over the header,
+//   - a quorum of commit votes aggregates into the orderer signature of the block, with the signers in Block.Signers.
+// Whatever the number of consenters a certificate is one G2 element and a bitmap. A consenter that times out moves to the
+// next view, and so does one that hears of a later view from more consenters than can be faulty. With a quorum of 2f+1
+// out of 3f+1 up to f consenters may be Byzantine.
+
+type BFTPhase byte
+
+const (
+	BFTPrepare BFTPhase = iota + 1
+	BFTCommit
+)
+
+const bftViewTimeout = 200 * time.Millisecond
+
+// Timeouts for views further ahead of the current one are dropped. Honest consenters enter one view per timeout or by
+// following others, so a view that far ahead only comes from a faulty consenter.
+const bftViewWindow = 64
+
+var (
+	ErrQuorumCertificate = errors.New("bft: invalid quorum certificate")
+	ErrBFTQueueFull      = errors.New("bft: the queue of every consenter is full")
+)
+
+// QuorumCertificate aggregates the votes of a quorum of consenters for a block in one phase
+type QuorumCertificate struct {
+	Phase     BFTPhase
+	View      uint64
+	Header    BlockHeader
+	Signers   SignerBitmap
+	Signature *blschia.G2Element
+}
+
+// bftVoteMessage is what a consenter signs to vote. Commit votes are over the header alone, so that the commit certificate
+// is the orderer signature of the block, and so do not depend on the view (a consenter only changes its commit vote for a
+// block with a later prepare certificate, as in Tendermint).
+func bftVoteMessage(phase BFTPhase, view uint64, header *BlockHeader) []byte {
+	if phase == BFTCommit {
+		return header.Bytes()
+	}
+	return EncodeProposal([]byte("bft-prepare"), binary.BigEndian.AppendUint64(nil, view), header.Hash())
+}
+
+func (qc *QuorumCertificate) Verify(scheme SignatureScheme, config *ChannelConfig) error {
+	if len(config.Consenters) == 0 {
+		return ErrQuorumCertificate
+	}
+	pks, err := config.OrdererKeys(qc.Signers)
+	if err != nil {
+		return err
+	}
+	if !verifySameMessage(scheme, pks, bftVoteMessage(qc.Phase, qc.View, &qc.Header), qc.Signature) {
+		return ErrQuorumCertificate
+	}
+	return nil
+}
+
+// Size of the certificate without the header: phase, view, bitmap and aggregate signature
+func (qc *QuorumCertificate) Size() int {
+	return 1 + 8 + len(qc.Signers) + len(qc.Signature.Serialize())
+}
+
+// ByzantineBehaviour makes a consenter deviate from the protocol, to check the others tolerate it
+type ByzantineBehaviour int
+
+const (
+	Honest              ByzantineBehaviour = iota
+	ByzantineSilent                        // Sends nothing at all (crashed or partitioned)
+	ByzantineEquivocate                    // As leader proposes a different block to each half of the consenters and votes for both
+	ByzantineBadVotes                      // Votes with signatures over the wrong message
+	ByzantineForge                         // Announces conflicting blocks as decided with a bitmap claiming every consenter signed
+)
+
+type bftMessageKind int
+
+const (
+	bftProposal bftMessageKind = iota
+	bftVote
+	bftTimeout
+	bftDecided
+)
+
+// Messages are authenticated by the channel they arrive on (TLS between orderers), votes are signed on top
+type bftMessage struct {
+	kind   bftMessageKind
+	from   int
+	height uint64
+	view   uint64
+	block  *Block             // Proposal, decided block or the block the sender of a timeout is locked on
+	lock   *QuorumCertificate // Prepare certificate of block in a proposal or timeout
+	phase  BFTPhase           // Vote
+	hash   string             // Vote: hash of the header voted for
+	sig    *blschia.G2Element // Vote
+}
+
+// BFTCluster runs every consenter of the channel in process
+type BFTCluster struct {
+	scheme   SignatureScheme
+	config   *ChannelConfig
+	guard    *ReplayGuard // Set when the channel has an ID
+	replicas []*bftReplica
+}
+
+type bftReplica struct {
+	cluster   *BFTCluster
+	index     int
+	sk        *blschia.PrivateKey
+	cuts      OrdererConfig
+	behaviour ByzantineBehaviour
+	inbox     chan bftMessage
+	submits   chan *Transaction
+	stop      chan struct{}
+
+	// Owned by the run goroutine
+	height    uint64
+	view      uint64
+	previous  *BlockHeader
+	queue     []*Transaction
+	committed map[string]bool // Proposals already in the ledger
+	ordered   *ReplayGuard    // Nonces of the proposals in the ledger, set when the channel has an ID
+	batch     <-chan time.Time
+	timer     <-chan time.Time
+	proposed  bool   // Led this view and proposed
+	accepted  *Block // Proposal prepare-voted in this view
+	prepared  bool   // Commit-voted in this view
+	locked    *Block
+	lock      *QuorumCertificate
+	known     *Block // Block of the latest prepare certificate heard of (own lock or from timeouts), re-proposed when leading
+	knownLock *QuorumCertificate
+	blocks    map[string]*Block                     // Proposals for this height by header hash
+	prepares  map[string]map[int]*blschia.G2Element // By view and header hash, nil for a vote that failed verification
+	commits   map[string]map[int]*blschia.G2Element // By header hash
+	timeouts  map[uint64]map[int]bool               // Consenters that moved to a view
+	future    []bftMessage                          // Messages for a later height or view
+
+	mu     sync.Mutex
+	ledger []*Block
+	update chan struct{}
+}
+
+// NewBFTCluster starts a consenter for every key in sks (sks[i] is the key of config.Consenters[i]), with the consenters in faults misbehaving
+func NewBFTCluster(scheme SignatureScheme, config *ChannelConfig, sks []*blschia.PrivateKey, cuts OrdererConfig, faults map[int]ByzantineBehaviour) *BFTCluster {
+	c := &BFTCluster{scheme: scheme, config: config}
+	if config.ChannelID != "" {
+		c.guard = NewReplayGuard(config.ChannelID, DefaultReplayCapacity, DefaultProposalTTL)
+	}
+	for i, sk := range sks {
+		r := &bftReplica{
+			cluster:   c,
+			index:     i,
+			sk:        sk,
+			cuts:      cuts,
+			behaviour: faults[i],
+			inbox:     make(chan bftMessage, 4096),
+			submits:   make(chan *Transaction, 1024),
+			stop:      make(chan struct{}),
+			committed: make(map[string]bool),
+			update:    make(chan struct{}),
+		}
+		if config.ChannelID != "" {
+			r.ordered = NewReplayGuard(config.ChannelID, DefaultReplayCapacity, DefaultProposalTTL)
+		}
+		r.reset()
+		c.replicas = append(c.replicas, r)
+	}
+	for _, r := range c.replicas {
+		if r.behaviour != ByzantineSilent {
+			go r.run()
+		}
+	}
+	return c
+}
+
+// Submit verifies the endorsement of tx, rejects replays and hands it to every consenter, so that any leader can order it.
+// It fails when no consenter had room for the transaction.
+func (c *BFTCluster) Submit(tx *Transaction) error {
+	if !c.config.VerifyEndorsement(c.scheme, tx) {
+		return ErrInvalidEndorsement
+	}
+	if c.guard != nil {
+		if _, err := c.guard.Check(tx.Proposal, time.Now()); err != nil {
+			return err
+		}
+	}
+	accepted := false
+	for _, r := range c.replicas {
+		select {
+		case r.submits <- tx:
+			accepted = true
+		default:
+		}
+	}
+	if !accepted {
+		return ErrBFTQueueFull
+	}
+	return nil
+}
+
+// Blocks returns the blocks in the ledger of consenter i from number start onwards and a channel closed when the next block is appended
+func (c *BFTCluster) Blocks(i int, start uint64) ([]*Block, <-chan struct{}) {
+	r := c.replicas[i]
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ledger[min(start, uint64(len(r.ledger))):], r.update
+}
+
+func (c *BFTCluster) Stop() {
+	for _, r := range c.replicas {
+		close(r.stop)
+	}
+}
+
+func (c *BFTCluster) send(to int, m bftMessage) {
+	select {
+	case c.replicas[to].inbox <- m:
+	default:
+	}
+}
+
+func (r *bftReplica) run() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case tx := <-r.submits:
+			r.enqueue(tx)
+		case m := <-r.inbox:
+			r.handle(m)
+		case <-r.batch:
+			r.batch = nil
+			r.propose(true)
+		case <-r.timer:
+			r.timer = nil
+			r.enterView(r.view + 1)
+		}
+	}
+}
+
+// broadcast sends m to the other consenters and handles it locally
+func (r *bftReplica) broadcast(m bftMessage) {
+	m.from, m.height = r.index, r.height
+	for to := range r.cluster.replicas {
+		if to != r.index {
+			r.cluster.send(to, m)
+		}
+	}
+	r.handle(m)
+}
+
+func (r *bftReplica) leader() int {
+	return int((r.height + r.view) % uint64(len(r.cluster.replicas)))
+}
+
+func (r *bftReplica) reset() {
+	r.view, r.accepted, r.proposed, r.prepared = 0, nil, false, false
+	r.locked, r.lock, r.known, r.knownLock = nil, nil, nil, nil
+	r.blocks = make(map[string]*Block)
+	r.prepares = make(map[string]map[int]*blschia.G2Element)
+	r.commits = make(map[string]map[int]*blschia.G2Element)
+	r.timeouts = make(map[uint64]map[int]bool)
+	r.timer, r.batch = nil, nil
+}
+
+// armTimer starts the view timer when there is something to order, doubling the timeout with every view up to 16 times
+func (r *bftReplica) armTimer() {
+	if r.timer == nil && (len(r.queue) > 0 || r.known != nil) {
+		r.timer = time.After(bftViewTimeout << min(r.view, 4))
+	}
+}
+
+func (r *bftReplica) enqueue(tx *Transaction) {
+	if r.committed[string(tx.Proposal)] {
+		return
+	}
+	r.queue = append(r.queue, tx)
+	r.armTimer()
+	if r.batch == nil && r.leader() == r.index {
+		r.batch = time.After(r.cuts.BatchTimeout)
+	}
+	r.propose(false)
+}
+
+// enterView moves to a later view, telling the others along with the block this consenter is locked on
+func (r *bftReplica) enterView(view uint64) {
+	r.view, r.accepted, r.proposed, r.prepared, r.timer = view, nil, false, false, nil
+	for earlier := range r.timeouts {
+		if earlier <= view {
+			delete(r.timeouts, earlier)
+		}
+	}
+	r.broadcast(bftMessage{kind: bftTimeout, view: view, block: r.locked, lock: r.lock})
+	r.start()
+}
+
+// start runs the current view: arm the timer, propose when leading and replay the messages that were early for it
+func (r *bftReplica) start() {
+	r.armTimer()
+	if r.leader() == r.index && r.batch == nil && len(r.queue) > 0 {
+		r.batch = time.After(r.cuts.BatchTimeout)
+	}
+	r.propose(false)
+	future := r.future
+	r.future = nil
+	for _, m := range future {
+		r.handle(m)
+	}
+}
+
+// propose is run by the leader once per view. A block some consenter is locked on has to be proposed again,
+// otherwise a block is cut from the queue when it is full or the batch timeout expired.
+func (r *bftReplica) propose(timeout bool) {
+	if r.leader() != r.index || r.proposed {
+		return
+	}
+	if r.known != nil {
+		r.proposed = true
+		r.broadcast(bftMessage{kind: bftProposal, view: r.view, block: r.known, lock: r.knownLock})
+		return
+	}
+	count := min(len(r.queue), r.cuts.MaxTransactions)
+	if count == 0 || (count < r.cuts.MaxTransactions && !timeout) {
+		return
+	}
+	r.proposed = true
+	block := NewBlock(r.previous, append([]*Transaction(nil), r.queue[:count]...))
+	if r.behaviour == ByzantineEquivocate && count > 1 {
+		r.equivocate(block)
+		return
+	}
+	r.broadcast(bftMessage{kind: bftProposal, view: r.view, block: block})
+}
+
+// equivocate sends block to half of the consenters and the same transactions in reverse order to the other half
+func (r *bftReplica) equivocate(block *Block) {
+	reversed := make([]*Transaction, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		reversed[len(reversed)-1-i] = tx
+	}
+	other := NewBlock(r.previous, reversed)
+	for to := range r.cluster.replicas {
+		if to == r.index {
+			continue
+		}
+		proposal := block
+		if to%2 == 1 {
+			proposal = other
+		}
+		r.cluster.send(to, bftMessage{kind: bftProposal, from: r.index, height: r.height, view: r.view, block: proposal})
+	}
+	for _, phase := range []BFTPhase{BFTPrepare, BFTCommit} {
+		r.vote(phase, block)
+		r.vote(phase, other)
+	}
+}
+
+func (r *bftReplica) handle(m bftMessage) {
+	if m.from < 0 || m.from >= len(r.cluster.replicas) || m.height < r.height {
+		return
+	}
+	if m.height > r.height || (m.kind == bftProposal && m.view > r.view) {
+		if len(r.future) < cap(r.inbox) {
+			r.future = append(r.future, m)
+		}
+		return
+	}
+	switch m.kind {
+	case bftProposal:
+		r.onProposal(m)
+	case bftVote:
+		r.onVote(m)
+	case bftTimeout:
+		r.onTimeout(m)
+	case bftDecided:
+		r.onDecided(m)
+	}
+}
+
+func (r *bftReplica) onProposal(m bftMessage) {
+	if m.view != r.view || m.from != r.leader() || r.accepted != nil {
+		return
+	}
+	block := m.block
+	if !validProposal(r.cluster.scheme, r.cluster.config, r.ordered, r.previous, block) {
+		return
+	}
+	hash := string(block.Header.Hash())
+	// A locked consenter only votes for another block with a later prepare certificate for it
+	if r.locked != nil && hash != string(r.locked.Header.Hash()) && !r.laterLock(m.lock, block) {
+		return
+	}
+	r.accepted = block
+	r.blocks[hash] = block
+	r.armTimer()
+	if r.behaviour == ByzantineForge {
+		r.forge(block)
+	}
+	r.vote(BFTPrepare, block)
+	r.checkCommitted(hash)
+}
+
+// laterLock reports whether lock is a valid prepare certificate for block from a later view than the lock of this consenter
+func (r *bftReplica) laterLock(lock *QuorumCertificate, block *Block) bool {
+	if lock == nil || lock.Phase != BFTPrepare || (r.lock != nil && lock.View <= r.lock.View) {
+		return false
+	}
+	return string(lock.Header.Hash()) == string(block.Header.Hash()) && lock.Verify(r.cluster.scheme, r.cluster.config) == nil
+}
+
+func (r *bftReplica) vote(phase BFTPhase, block *Block) {
+	msg := bftVoteMessage(phase, r.view, &block.Header)
+	if r.behaviour == ByzantineBadVotes {
+		msg = append(msg, 0)
+	}
+	r.broadcast(bftMessage{kind: bftVote, view: r.view, phase: phase, hash: string(block.Header.Hash()), sig: r.cluster.scheme.Sign(r.sk, msg)})
+}
+
+func bftPrepareKey(view uint64, hash string) string {
+	return fmt.Sprintf("%d/%s", view, hash)
+}
+
+func (r *bftReplica) onVote(m bftMessage) {
+	votes := r.commits
+	key := m.hash
+	if m.phase == BFTPrepare {
+		votes, key = r.prepares, bftPrepareKey(m.view, m.hash)
+	}
+	if votes[key] == nil {
+		votes[key] = make(map[int]*blschia.G2Element)
+	}
+	if _, found := votes[key][m.from]; found {
+		return
+	}
+	votes[key][m.from] = m.sig
+	if m.phase == BFTPrepare {
+		r.checkPrepared()
+	} else {
+		r.checkCommitted(m.hash)
+	}
+}
+
+// checkPrepared locks the accepted block and commit-votes for it once a quorum prepare-voted for it in this view
+func (r *bftReplica) checkPrepared() {
+	if r.accepted == nil || r.prepared {
+		return
+	}
+	qc := r.certificate(BFTPrepare, r.view, r.accepted, r.prepares[bftPrepareKey(r.view, string(r.accepted.Header.Hash()))])
+	if qc == nil {
+		return
+	}
+	r.prepared = true
+	r.locked, r.lock = r.accepted, qc
+	r.known, r.knownLock = r.accepted, qc
+	r.vote(BFTCommit, r.accepted)
+}
+
+// checkCommitted decides a block once a quorum commit-voted for it
+func (r *bftReplica) checkCommitted(hash string) {
+	block := r.blocks[hash]
+	if block == nil {
+		return
+	}
+	qc := r.certificate(BFTCommit, 0, block, r.commits[hash])
+	if qc == nil {
+		return
+	}
+	decided := &Block{Header: block.Header, Transactions: block.Transactions, Signers: qc.Signers, OrdererSignature: qc.Signature}
+	decided.aggregate(r.cluster.scheme)
+	r.broadcast(bftMessage{kind: bftDecided, block: decided})
+}
+
+// certificate aggregates votes once there are enough of them. The aggregate is verified once, only when that fails
+// are the votes verified one by one to weed out the bad ones.
+func (r *bftReplica) certificate(phase BFTPhase, view uint64, block *Block, votes map[int]*blschia.G2Element) *QuorumCertificate {
+	config := r.cluster.config
+	msg := bftVoteMessage(phase, view, &block.Header)
+	for retry := true; ; retry = false {
+		signers := NewSignerBitmap(len(config.Consenters))
+		var pks []*blschia.G1Element
+		var sigs []*blschia.G2Element
+		for i, pk := range config.Consenters {
+			if votes[i] != nil {
+				signers.Set(i)
+				pks = append(pks, pk)
+				sigs = append(sigs, votes[i])
+			}
+		}
+		if len(sigs) < config.ConsenterQuorum {
+			return nil
+		}
+		sig := r.cluster.scheme.AggregateSigs(sigs...)
+		if verifySameMessage(r.cluster.scheme, pks, msg, sig) {
+			return &QuorumCertificate{Phase: phase, View: view, Header: block.Header, Signers: signers, Signature: sig}
+		}
+		if !retry {
+			return nil
+		}
+		for i, vote := range votes {
+			if vote != nil && !r.cluster.scheme.Verify(config.Consenters[i], msg, vote) {
+				votes[i] = nil
+			}
+		}
+	}
+}
+
+// onTimeout notes that a consenter moved to a later view and the block it is locked on. Once more consenters than can
+// be faulty are in later views this one follows.
+func (r *bftReplica) onTimeout(m bftMessage) {
+	if m.view <= r.view || m.view > r.view+bftViewWindow {
+		return
+	}
+	if r.timeouts[m.view] == nil {
+		r.timeouts[m.view] = make(map[int]bool)
+	}
+	r.timeouts[m.view][m.from] = true
+	if m.block != nil && m.lock != nil && (r.knownLock == nil || m.lock.View > r.knownLock.View) &&
+		validProposal(r.cluster.scheme, r.cluster.config, r.ordered, r.previous, m.block) &&
+		string(m.lock.Header.Hash()) == string(m.block.Header.Hash()) && m.lock.Verify(r.cluster.scheme, r.cluster.config) == nil {
+		r.known, r.knownLock = m.block, m.lock
+	}
+
+	faulty := len(r.cluster.replicas) - r.cluster.config.ConsenterQuorum
+	// A consenter in a view is past every earlier view too, so count the senders from the latest view down
+	views := make([]uint64, 0, len(r.timeouts))
+	for view := range r.timeouts {
+		if view > r.view {
+			views = append(views, view)
+		}
+	}
+	slices.Sort(views)
+	senders := make(map[int]bool)
+	for i := len(views) - 1; i >= 0; i-- {
+		for sender := range r.timeouts[views[i]] {
+			senders[sender] = true
+		}
+		if len(senders) > faulty {
+			r.enterView(views[i])
+			return
+		}
+	}
+}
+
+// onDecided appends a decided block to the ledger if it carries a valid commit certificate
+func (r *bftReplica) onDecided(m bftMessage) {
+	block := m.block
+	if !validProposal(r.cluster.scheme, r.cluster.config, r.ordered, r.previous, block) || !r.cluster.config.VerifyBlock(r.cluster.scheme, block) {
+		return
+	}
+	r.mu.Lock()
+	r.ledger = append(r.ledger, block)
+	close(r.update)
+	r.update = make(chan struct{})
+	r.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		r.committed[string(tx.Proposal)] = true
+	}
+	if r.ordered != nil {
+		r.ordered.Ordered(transactionLeaves(block.Transactions))
+	}
+	var queue []*Transaction
+	for _, tx := range r.queue {
+		if !r.committed[string(tx.Proposal)] {
+			queue = append(queue, tx)
+		}
+	}
+	r.queue = queue
+	r.previous = &block.Header
+	r.height++
+	r.reset()
+	r.start()
+}
+
+// forge announces the block and a conflicting one as decided, signed by this consenter alone but claiming every consenter signed
+func (r *bftReplica) forge(block *Block) {
+	for _, txs := range [][]*Transaction{block.Transactions, block.Transactions[:len(block.Transactions)/2]} {
+		forged := NewBlock(r.previous, txs)
+		all := NewSignerBitmap(len(r.cluster.replicas))
+		for i := range r.cluster.replicas {
+			all.Set(i)
+		}
+		forged.SignQuorum(r.cluster.scheme, all, []*blschia.G2Element{r.cluster.scheme.Sign(r.sk, forged.Header.Bytes())})
+		for to := range r.cluster.replicas {
+			if to != r.index {
+				r.cluster.send(to, bftMessage{kind: bftDecided, from: r.index, height: r.height, block: forged})
+			}
+		}
+	}
+}
+
+// Four orderer organisations order blocks with one of them down, and a peer checks the commit certificate of every block
+func BFTOrderingExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	config, sks, _ := exampleChannel(scheme)
+	consenter_sks := exampleConsenters(scheme, config, 4, 3)
+	cluster := NewBFTCluster(scheme, config, consenter_sks, OrdererConfig{MaxTransactions: 4, BatchTimeout: 20 * time.Millisecond},
+		map[int]ByzantineBehaviour{0: ByzantineSilent})
+	defer cluster.Stop()
+
+	for i := 0; i < 12; i++ {
+		proposal, _ := makeRandomArray(1000)
+		if err := cluster.Submit(exampleEndorse(scheme, config, sks, proposal)); err != nil {
+			panic(err)
+		}
+	}
+	deadline := time.After(10 * time.Second)
+	for {
+		blocks, update := cluster.Blocks(1, 0)
+		committed := 0
+		for _, block := range blocks {
+			committed += len(block.Transactions)
+		}
+		if committed == 12 {
+			for _, block := range blocks {
+				if !config.VerifyBlock(scheme, block) {
+					panic("failed a verification of a BFT ordered block")
+				}
+			}
+			qc := QuorumCertificate{Signers: blocks[0].Signers, Signature: blocks[0].OrdererSignature}
+			fmt.Printf("bft: %d blocks, signers of the first %v, certificate %d bytes instead of %d for separate votes\n",
+				len(blocks), blocks[0].Signers.Indices(), qc.Size(), len(blocks[0].Signers.Indices())*len(qc.Signature.Serialize()))
+			return
+		}
+		select {
+		case <-update:
+		case <-deadline:
+			panic("BFT ordering made no progress")
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// runBFT orders count transactions on n consenters with the given faults and checks that every honest consenter ends up
+// with the same ledger of blocks carrying valid commit certificates
+func runBFT(t *testing.T, n int, faults map[int]ByzantineBehaviour, count int) {
+	t.Helper()
+	scheme := blschia.NewAugSchemeMPL()
+	config, sks, _ := exampleChannel(scheme)
+	consenter_sks := exampleConsenters(scheme, config, n, n-(n-1)/3)
+	cluster := NewBFTCluster(scheme, config, consenter_sks, OrdererConfig{MaxTransactions: 4, BatchTimeout: 10 * time.Millisecond}, faults)
+	defer cluster.Stop()
+
+	for i := 0; i < count; i++ {
+		proposal, _ := makeRandomArray(256)
+		if err := cluster.Submit(exampleEndorse(scheme, config, sks, proposal)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reference []*Block
+	deadline := time.After(20 * time.Second)
+	for i := 0; i < n; i++ {
+		if faults[i] != Honest {
+			continue
+		}
+		var blocks []*Block
+		for {
+			var update <-chan struct{}
+			blocks, update = cluster.Blocks(i, 0)
+			committed := 0
+			for _, block := range blocks {
+				committed += len(block.Transactions)
+			}
+			if committed == count {
+				break
+			}
+			if committed > count {
+				t.Fatalf("consenter %d committed %d of %d transactions", i, committed, count)
+			}
+			select {
+			case <-update:
+			case <-deadline:
+				t.Fatalf("consenter %d committed %d of %d transactions", i, committed, count)
+			}
+		}
+		for _, block := range blocks {
+			if !config.VerifyBlock(scheme, block) {
+				t.Fatalf("consenter %d has an invalid block %d", i, block.Header.Number)
+			}
+			if len(block.Signers.Indices()) < config.ConsenterQuorum {
+				t.Fatalf("block %d signed by %v only", block.Header.Number, block.Signers.Indices())
+			}
+		}
+		if reference == nil {
+			reference = blocks
+			continue
+		}
+		if len(blocks) != len(reference) {
+			t.Fatalf("consenter %d has %d blocks instead of %d", i, len(blocks), len(reference))
+		}
+		for j := range blocks {
+			if !bytes.Equal(blocks[j].Header.Hash(), reference[j].Header.Hash()) {
+				t.Fatalf("consenters disagree on block %d", j)
+			}
+		}
+	}
+}
+
+func TestBFTHonest(t *testing.T) {
+	runBFT(t, 4, nil, 12)
+}
+
+func TestBFTSilentLeader(t *testing.T) {
+	runBFT(t, 4, map[int]ByzantineBehaviour{0: ByzantineSilent}, 12)
+}
+
+func TestBFTEquivocatingLeader(t *testing.T) {
+	runBFT(t, 4, map[int]ByzantineBehaviour{0: ByzantineEquivocate}, 12)
+}
+
+func TestBFTBadVotes(t *testing.T) {
+	runBFT(t, 4, map[int]ByzantineBehaviour{1: ByzantineBadVotes}, 12)
+}
+
+func TestBFTForgedDecisions(t *testing.T) {
+	runBFT(t, 4, map[int]ByzantineBehaviour{2: ByzantineForge}, 12)
+}
+
+func TestBFTTwoFaults(t *testing.T) {
+	runBFT(t, 7, map[int]ByzantineBehaviour{0: ByzantineEquivocate, 3: ByzantineSilent}, 16)
+}
+
+// A timeout for a view far ahead is dropped instead of stalling the consenter, and a nearer view is followed once more
+// consenters than can be faulty are in it or later. The consenters are not started so their state can be inspected.
+func TestBFTTimeoutViews(t *testing.T) {
+	scheme := blschia.NewAugSchemeMPL()
+	config, _, _ := exampleChannel(scheme)
+	faults := map[int]ByzantineBehaviour{0: ByzantineSilent, 1: ByzantineSilent, 2: ByzantineSilent, 3: ByzantineSilent}
+	cluster := NewBFTCluster(scheme, config, exampleConsenters(scheme, config, 4, 3), OrdererConfig{MaxTransactions: 4, BatchTimeout: time.Second}, faults)
+	defer cluster.Stop()
+	r := cluster.replicas[0]
+
+	for from := 1; from < 4; from++ {
+		r.onTimeout(bftMessage{kind: bftTimeout, from: from, view: 1 << 63})
+	}
+	if r.view != 0 || len(r.timeouts) != 0 {
+		t.Fatalf("followed or kept a timeout for view 2^63: view %d, %d views noted", r.view, len(r.timeouts))
+	}
+	r.onTimeout(bftMessage{kind: bftTimeout, from: 1, view: 5})
+	if r.view != 0 {
+		t.Fatalf("followed a single consenter to view %d", r.view)
+	}
+	r.onTimeout(bftMessage{kind: bftTimeout, from: 2, view: 3})
+	if r.view != 3 {
+		t.Fatalf("in view %d, expected 3", r.view)
+	}
+}
+
+func TestQuorumCertificateVerify(t *testing.T) {
+	scheme := blschia.NewAugSchemeMPL()
+	config, _, _ := exampleChannel(scheme)
+	consenter_sks := exampleConsenters(scheme, config, 4, 3)
+	header := NewBlock(nil, nil).Header
+
+	certificate := func(phase BFTPhase, view uint64, signers ...int) *QuorumCertificate {
+		qc := &QuorumCertificate{Phase: phase, View: view, Header: header, Signers: NewSignerBitmap(4)}
+		var sigs []*blschia.G2Element
+		for _, i := range signers {
+			qc.Signers.Set(i)
+			sigs = append(sigs, scheme.Sign(consenter_sks[i], bftVoteMessage(phase, view, &header)))
+		}
+		qc.Signature = scheme.AggregateSigs(sigs...)
+		return qc
+	}
+
+	if err := certificate(BFTPrepare, 2, 0, 1, 3).Verify(scheme, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := certificate(BFTCommit, 0, 0, 1, 2, 3).Verify(scheme, config); err != nil {
+		t.Fatal(err)
+	}
+	if certificate(BFTPrepare, 2, 0, 1).Verify(scheme, config) == nil {
+		t.Fatal("accepted a certificate below the quorum")
+	}
+	// Claiming a consenter signed that did not
+	qc := certificate(BFTPrepare, 2, 0, 1, 3)
+	qc.Signers.Set(2)
+	if qc.Verify(scheme, config) == nil {
+		t.Fatal("accepted a certificate with a wrong bitmap")
+	}
+	// Votes from another view
+	qc = certificate(BFTPrepare, 2, 0, 1, 3)
+	qc.View = 3
+	if qc.Verify(scheme, config) == nil {
+		t.Fatal("accepted a certificate for another view")
+	}
+	// A bitmap sized for more consenters than the channel has
+	qc = certificate(BFTCommit, 0, 0, 1, 3)
+	qc.Signers = append(qc.Signers, 0)
+	if qc.Verify(scheme, config) == nil {
+		t.Fatal("accepted a certificate with an oversized bitmap")
+	}
+}
+
+// Neither cluster accepts a replayed submission, and a leader including a transaction ordered before or the same
+// transaction twice has its block rejected by the consenters
+func TestClusterReplay(t *testing.T) {
+	scheme := blschia.NewAugSchemeMPL()
+	config, sks, _ := exampleChannel(scheme)
+	config.ChannelID = "upi-p2p"
+	consenter_sks := exampleConsenters(scheme, config, 4, 3)
+	cuts := OrdererConfig{MaxTransactions: 4, BatchTimeout: time.Second}
+	silent := map[int]ByzantineBehaviour{0: ByzantineSilent, 1: ByzantineSilent, 2: ByzantineSilent, 3: ByzantineSilent}
+	bft := NewBFTCluster(scheme, config, consenter_sks, cuts, silent)
+	defer bft.Stop()
+	raft := NewRaftCluster(scheme, config, consenter_sks, cuts)
+	defer raft.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := raft.WaitLeader(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	txs := make([]*Transaction, 2)
+	for i := range txs {
+		proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", uint64(100_00*(i+1))).Bytes(), time.Minute).Bytes()
+		txs[i] = exampleEndorse(scheme, config, sks, proposal)
+	}
+	for name, submit := range map[string]func(*Transaction) error{"bft": bft.Submit, "raft": raft.Submit} {
+		if err := submit(txs[0]); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := submit(txs[0]); !errors.Is(err, ErrReplay) {
+			t.Fatalf("%s accepted a replayed transaction: %v", name, err)
+		}
+	}
+
+	r := bft.replicas[0]
+	first := NewBlock(nil, txs[:1])
+	if !validProposal(scheme, config, r.ordered, nil, first) {
+		t.Fatal("rejected a valid proposal")
+	}
+	if validProposal(scheme, config, r.ordered, nil, NewBlock(nil, []*Transaction{txs[1], txs[1]})) {
+		t.Fatal("accepted a proposal with the same transaction twice")
+	}
+	r.ordered.Ordered(transactionLeaves(first.Transactions))
+	if validProposal(scheme, config, r.ordered, &first.Header, NewBlock(&first.Header, txs)) {
+		t.Fatal("accepted a proposal with a transaction ordered before")
+	}
+
+	// A guard asked for no room still holds one nonce
+	guard := NewReplayGuard(config.ChannelID, 0, DefaultProposalTTL)
+	if _, err := guard.Check(txs[0].Proposal, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := guard.Check(txs[0].Proposal, time.Now()); err == nil {
+		t.Fatal("guard accepted a replay")
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// SignatureScheme is the subset of blschia.Scheme needed by the endorse-order-commit flow.
+// It is satisfied by the blschia schemes as well as by DigestScheme.
+type SignatureScheme interface {
+	Sign(sk *blschia.PrivateKey, msg []byte) *blschia.G2Element
+	Verify(pk *blschia.G1Element, msg []byte, sig *blschia.G2Element) bool
+	AggregateSigs(sigs ...*blschia.G2Element) *blschia.G2Element
+	AggregateVerify(pks []*blschia.G1Element, msgs [][]byte, sig *blschia.G2Element) bool
+}
+
+// Transaction is an endorsed proposal: the proposal bytes together with the aggregate of its endorsements
+type Transaction struct {
+	Proposal    []byte
+	Endorsement *blschia.G2Element
+	Endorsers   []string // Organisations whose endorsements are aggregated, when not implied by the policy (Fabric encoding only)
+}
+
+// BlockHeader is what the orderer signs. The transactions are only committed to through their Merkle root
+// so a single transaction can be proven to be in a block without the rest of the block.
+type BlockHeader struct {
+	Number           uint64
+	PreviousHash     []byte
+	TransactionsRoot []byte
+	TransactionCount uint32
+}
+
+// Block carries two signatures: the orderer's signature over the header on its own (needed by light clients) and
+// the aggregate of that signature with every transaction endorsement (verified by full peers in one AggregateVerify).
+// When the channel is ordered by a cluster, OrdererSignature aggregates the header signatures of the consenters listed in Signers.
+type Block struct {
+	Header             BlockHeader
+	Transactions       []*Transaction
+	OrdererSignature   *blschia.G2Element
+	AggregateSignature *blschia.G2Element
+	Signers            SignerBitmap // Consenters that signed the header (empty for a single orderer)
+}
+
+// SignerBitmap has bit i (bit i%8 of byte i/8) set when consenter i of the channel signed
+type SignerBitmap []byte
+
+func NewSignerBitmap(n int) SignerBitmap {
+	return make(SignerBitmap, (n+7)/8)
+}
+
+func (b SignerBitmap) Set(i int) {
+	b[i/8] |= 1 << (i % 8)
+}
+
+func (b SignerBitmap) Has(i int) bool {
+	return i >= 0 && i/8 < len(b) && b[i/8]&(1<<(i%8)) != 0
+}
+
+// Indices lists the signers in increasing order
+func (b SignerBitmap) Indices() []int {
+	var indices []int
+	for i := 0; i < 8*len(b); i++ {
+		if b.Has(i) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+var ErrTransactionIndex = errors.New("block: transaction index out of range")
+
+// Bytes returns the canonical header encoding (the message signed by the orderer)
+func (h *BlockHeader) Bytes() []byte {
+	var number [8]byte
+	var count [4]byte
+	binary.BigEndian.PutUint64(number[:], h.Number)
+	binary.BigEndian.PutUint32(count[:], h.TransactionCount)
+	return EncodeProposal([]byte("block-header"), number[:], h.PreviousHash, h.TransactionsRoot, count[:])
+}
+
+func (h *BlockHeader) Hash() []byte {
+	sum := sha256.Sum256(h.Bytes())
+	return sum[:]
+}
+
+// decodeHeaderBytes is the inverse of BlockHeader.Bytes. It reports false for any other message.
+func decodeHeaderBytes(data []byte) (*BlockHeader, bool) {
+	var fields [][]byte
+	for rest := data; len(rest) > 0; {
+		if len(rest) < 8 || binary.BigEndian.Uint64(rest) > uint64(len(rest)-8) {
+			return nil, false
+		}
+		length := binary.BigEndian.Uint64(rest)
+		fields = append(fields, rest[8:8+length])
+		rest = rest[8+length:]
+	}
+	if len(fields) != 5 || string(fields[0]) != "block-header" || len(fields[1]) != 8 || len(fields[4]) != 4 {
+		return nil, false
+	}
+	header := &BlockHeader{
+		Number:           binary.BigEndian.Uint64(fields[1]),
+		PreviousHash:     fields[2],
+		TransactionsRoot: fields[3],
+		TransactionCount: binary.BigEndian.Uint32(fields[4]),
+	}
+	if len(header.PreviousHash) == 0 {
+		header.PreviousHash = nil
+	}
+	return header, true
+}
+
+func transactionLeaves(txs []*Transaction) [][]byte {
+	leaves := make([][]byte, len(txs))
+	for i, tx := range txs {
+		leaves[i] = tx.Proposal
+	}
+	return leaves
+}
+
+// NewBlock cuts a block out of txs chained after previous (nil for the genesis block)
+func NewBlock(previous *BlockHeader, txs []*Transaction) *Block {
+	header := BlockHeader{
+		TransactionsRoot: MerkleRoot(transactionLeaves(txs)),
+		TransactionCount: uint32(len(txs)),
+	}
+	if previous != nil {
+		header.Number = previous.Number + 1
+		header.PreviousHash = previous.Hash()
+	}
+	return &Block{Header: header, Transactions: txs}
+}
+
+// Sign is run by the orderer once it has verified every transaction endorsement
+func (b *Block) Sign(scheme SignatureScheme, ordererSk *blschia.PrivateKey) {
+	b.OrdererSignature = scheme.Sign(ordererSk, b.Header.Bytes())
+	b.aggregate(scheme)
+}
+
+// SignQuorum sets the orderer signature to the aggregate of the header signatures of a quorum of consenters.
+// sigs are the signatures of the consenters set in signers, in increasing order of their index.
+func (b *Block) SignQuorum(scheme SignatureScheme, signers SignerBitmap, sigs []*blschia.G2Element) {
+	b.Signers = signers
+	b.OrdererSignature = scheme.AggregateSigs(sigs...)
+	b.aggregate(scheme)
+}
+
+func (b *Block) aggregate(scheme SignatureScheme) {
+	sigs := make([]*blschia.G2Element, 0, len(b.Transactions)+1)
+	for _, tx := range b.Transactions {
+		sigs = append(sigs, tx.Endorsement)
+	}
+	sigs = append(sigs, b.OrdererSignature)
+	b.AggregateSignature = scheme.AggregateSigs(sigs...)
+}
+
+// Verify is run by full peers. ordererPks are the keys that signed the header (see ChannelConfig.OrdererKeys) and
+// endorsers are the public keys every transaction is endorsed by (the endorsement policy).
+// The header is checked against the transactions as well, otherwise the Merkle root could disagree with the block body.
+func (b *Block) Verify(scheme SignatureScheme, ordererPks []*blschia.G1Element, endorsers []*blschia.G1Element) bool {
+	return b.verify(scheme, ordererPks, func(tx *Transaction) ([]*blschia.G1Element, []byte, error) {
+		return endorsers, tx.Proposal, nil
+	})
+}
+
+// verify is Verify with signers giving the keys that signed each transaction and the message they signed
+func (b *Block) verify(scheme SignatureScheme, ordererPks []*blschia.G1Element, signers func(*Transaction) ([]*blschia.G1Element, []byte, error)) bool {
+	if b.AggregateSignature == nil {
+		return false
+	}
+	if int(b.Header.TransactionCount) != len(b.Transactions) {
+		return false
+	}
+	if !bytes.Equal(MerkleRoot(transactionLeaves(b.Transactions)), b.Header.TransactionsRoot) {
+		return false
+	}
+	pks := make([]*blschia.G1Element, 0, len(ordererPks)+len(b.Transactions))
+	msgs := make([][]byte, 0, cap(pks))
+	header := b.Header.Bytes()
+	for _, pk := range ordererPks {
+		pks = append(pks, pk)
+		msgs = append(msgs, header)
+	}
+	for _, tx := range b.Transactions {
+		txPks, msg, err := signers(tx)
+		if err != nil {
+			return false
+		}
+		for _, pk := range txPks {
+			pks = append(pks, pk)
+			msgs = append(msgs, msg)
+		}
+	}
+	return scheme.AggregateVerify(pks, msgs, b.AggregateSignature)
+}
+
+// InclusionProof returns the Merkle proof that the i-th transaction belongs to the block
+func (b *Block) InclusionProof(i int) (*MerkleProof, error) {
+	if i < 0 || i >= len(b.Transactions) {
+		return nil, ErrTransactionIndex
+	}
+	return NewMerkleProof(transactionLeaves(b.Transactions), i)
+}
+
+// VerifyHeader checks the orderer signature over a header on its own (the aggregate of the signatures of every key in ordererPks)
+func VerifyHeader(scheme SignatureScheme, ordererPks []*blschia.G1Element, header *BlockHeader, ordererSig *blschia.G2Element) bool {
+	return verifySameMessage(scheme, ordererPks, header.Bytes(), ordererSig)
+}
+
+// verifySameMessage checks an aggregate of signatures over msg by every key in pks
+func verifySameMessage(scheme SignatureScheme, pks []*blschia.G1Element, msg []byte, sig *blschia.G2Element) bool {
+	if sig == nil || len(pks) == 0 {
+		return false
+	}
+	if len(pks) == 1 {
+		return scheme.Verify(pks[0], msg, sig)
+	}
+	msgs := make([][]byte, len(pks))
+	for i := range msgs {
+		msgs[i] = msg
+	}
+	return scheme.AggregateVerify(pks, msgs, sig)
+}
+
+// VerifyInclusion checks that proposal is committed to by the header (the header signature must be checked separately)
+func VerifyInclusion(header *BlockHeader, proposal []byte, proof *MerkleProof) bool {
+	return proof != nil && proof.LeafCount == int(header.TransactionCount) && VerifyMerkleProof(header.TransactionsRoot, proposal, proof)
+}
+
+// An orderer signing the Merkle root of a block and an auditor checking a single UPI transfer against the signed header
+func MerkleBlockExample() {
+	npci_seed, _ := makeRandomArray(32)
+	rbi_seed, _ := makeRandomArray(32)
+	sbi_seed, _ := makeRandomArray(32)
+	hdfc_seed, _ := makeRandomArray(32)
+	orderer_seed, _ := makeRandomArray(32)
+
+	scheme := blschia.NewAugSchemeMPL()
+
+	npci_sk, _ := scheme.KeyGen(npci_seed)
+	rbi_sk, _ := scheme.KeyGen(rbi_seed)
+	sbi_sk, _ := scheme.KeyGen(sbi_seed)
+	hdfc_sk, _ := scheme.KeyGen(hdfc_seed)
+	orderer_sk, _ := scheme.KeyGen(orderer_seed)
+
+	npci_pk, _ := npci_sk.G1Element()
+	rbi_pk, _ := rbi_sk.G1Element()
+	sbi_pk, _ := sbi_sk.G1Element()
+	hdfc_pk, _ := hdfc_sk.G1Element()
+	orderer_pk, _ := orderer_sk.G1Element()
+	endorsers := []*blschia.G1Element{npci_pk, rbi_pk, sbi_pk, hdfc_pk}
+
+	txs := make([]*Transaction, 5)
+	for i := range txs {
+		proposal, _ := makeRandomArray(5000)
+		endorsement := scheme.AggregateSigs(scheme.Sign(npci_sk, proposal), scheme.Sign(rbi_sk, proposal), scheme.Sign(sbi_sk, proposal), scheme.Sign(hdfc_sk, proposal))
+		txs[i] = &Transaction{Proposal: proposal, Endorsement: endorsement}
+	}
+
+	genesis := NewBlock(nil, txs[:2])
+	genesis.Sign(scheme, orderer_sk)
+	block := NewBlock(&genesis.Header, txs[2:])
+	block.Sign(scheme, orderer_sk)
+
+	// Full peers verify whole blocks
+	if !genesis.Verify(scheme, []*blschia.G1Element{orderer_pk}, endorsers) || !block.Verify(scheme, []*blschia.G1Element{orderer_pk}, endorsers) {
+		panic("failed a verification of the block signature")
+	}
+
+	// An auditor only needs the signed header, the transaction and its proof
+	proof, _ := block.InclusionProof(1)
+	if !VerifyHeader(scheme, []*blschia.G1Element{orderer_pk}, &block.Header, block.OrdererSignature) {
+		panic("failed a verification of the block header signature")
+	}
+	if !VerifyInclusion(&block.Header, txs[3].Proposal, proof) {
+		panic("failed a verification of the transaction inclusion proof")
+	}
+	if VerifyInclusion(&block.Header, txs[2].Proposal, proof) {
+		panic("inclusion proof verified for the wrong transaction")
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Channels. Each channel has its own ChannelConfig (members, endorsement policy and orderer set) and its own ledger, and
+// a ChannelPeer keeps one ledger for every channel it joined. Proposals name their channel in their envelope (see
+// replay.go), so a peer always verifies a transaction against the keys of the transaction's own channel and never
+// against those of another channel that happens to have the same organisations.
+
+var (
+	ErrUnknownChannel = errors.New("channel: not a member of the channel")
+	ErrChannelJoined  = errors.New("channel: already joined")
+	ErrChannelID      = errors.New("channel: configuration has no channel ID")
+	ErrLedgerChain    = errors.New("channel: block does not extend the ledger")
+)
+
+// ProposalChannel is the channel a proposal is bound to
+func ProposalChannel(proposal []byte) (string, error) {
+	e, err := DecodeProposalEnvelope(proposal)
+	if err != nil {
+		return "", err
+	}
+	return e.Channel, nil
+}
+
+type channelLedger struct {
+	configs []*ChannelConfig // Every configuration version, the last one current
+	since   []uint64         // Number of the first block each configuration applies to
+	blocks  []*Block
+	update  chan struct{} // Closed and replaced whenever a block is committed
+}
+
+// ChannelPeer is a peer that can be a member of several channels
+type ChannelPeer struct {
+	scheme SignatureScheme
+
+	mu       sync.Mutex
+	channels map[string]*channelLedger
+}
+
+func NewChannelPeer(scheme SignatureScheme) *ChannelPeer {
+	return &ChannelPeer{scheme: scheme, channels: make(map[string]*channelLedger)}
+}
+
+// Join starts an empty ledger for the channel of config
+func (p *ChannelPeer) Join(config *ChannelConfig) error {
+	if config.ChannelID == "" {
+		return ErrChannelID
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, found := p.channels[config.ChannelID]; found {
+		return fmt.Errorf("%w: %s", ErrChannelJoined, config.ChannelID)
+	}
+	p.channels[config.ChannelID] = &channelLedger{configs: []*ChannelConfig{config}, since: []uint64{0}, update: make(chan struct{})}
+	return nil
+}
+
+// Channels lists the joined channels in order
+func (p *ChannelPeer) Channels() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, 0, len(p.channels))
+	for id := range p.channels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ledger must be called with p.mu held
+func (p *ChannelPeer) ledger(channel string) (*channelLedger, error) {
+	ledger, found := p.channels[channel]
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrUnknownChannel, channel)
+	}
+	return ledger, nil
+}
+
+// Config is the current configuration of channel
+func (p *ChannelPeer) Config(channel string) (*ChannelConfig, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ledger, err := p.ledger(channel)
+	if err != nil {
+		return nil, err
+	}
+	return ledger.configs[len(ledger.configs)-1], nil
+}
+
+// ConfigAt is the configuration block number of channel was (or will be) verified against
+func (p *ChannelPeer) ConfigAt(channel string, number uint64) (*ChannelConfig, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ledger, err := p.ledger(channel)
+	if err != nil {
+		return nil, err
+	}
+	i := len(ledger.since) - 1
+	for ledger.since[i] > number {
+		i--
+	}
+	return ledger.configs[i], nil
+}
+
+// VerifyTransaction checks the endorsement of tx against the policy of the channel its proposal names
+func (p *ChannelPeer) VerifyTransaction(tx *Transaction) error {
+	channel, err := ProposalChannel(tx.Proposal)
+	if err != nil {
+		return err
+	}
+	config, err := p.Config(channel)
+	if err != nil {
+		return err
+	}
+	if !config.VerifyEndorsement(p.scheme, tx) {
+		return ErrInvalidEndorsement
+	}
+	return nil
+}
+
+// Commit verifies block against the current configuration of channel and appends it to the channel's ledger. The
+// configuration transaction of a configuration block applies from the next block on.
+func (p *ChannelPeer) Commit(channel string, block *Block) error {
+	config, err := p.Config(channel)
+	if err != nil {
+		return err
+	}
+	if !config.VerifyBlock(p.scheme, block) {
+		return fmt.Errorf("channel: block %d failed verification on %s", block.Header.Number, channel)
+	}
+	var next *ChannelConfig
+	if IsConfigBlock(block) {
+		t, err := DecodeConfigTransaction(block.Transactions[0])
+		if err != nil {
+			return err
+		}
+		if next, err = config.ApplyConfig(p.scheme, t); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ledger := p.channels[channel]
+	if ledger.configs[len(ledger.configs)-1] != config {
+		return fmt.Errorf("%w: configuration of %s changed during the commit", ErrLedgerChain, channel)
+	}
+	height := uint64(len(ledger.blocks))
+	if block.Header.Number != height {
+		return fmt.Errorf("%w: block %d at height %d of %s", ErrLedgerChain, block.Header.Number, height, channel)
+	}
+	if height > 0 && !bytes.Equal(block.Header.PreviousHash, ledger.blocks[height-1].Header.Hash()) {
+		return fmt.Errorf("%w: block %d of %s", ErrLedgerChain, block.Header.Number, channel)
+	}
+	ledger.blocks = append(ledger.blocks, block)
+	if next != nil {
+		ledger.configs = append(ledger.configs, next)
+		ledger.since = append(ledger.since, height+1)
+	}
+	close(ledger.update)
+	ledger.update = make(chan struct{})
+	return nil
+}
+
+// Blocks returns the committed blocks of channel from number start onwards and a channel closed when the next one is committed
+func (p *ChannelPeer) Blocks(channel string, start uint64) ([]*Block, <-chan struct{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ledger, err := p.ledger(channel)
+	if err != nil {
+		return nil, nil, err
+	}
+	if start > uint64(len(ledger.blocks)) {
+		start = uint64(len(ledger.blocks))
+	}
+	// Clipped so that appending to the result never writes into the ledger
+	return ledger.blocks[start:len(ledger.blocks):len(ledger.blocks)], ledger.update, nil
+}
+
+// Follow commits the blocks of channel delivered by orderer, continuing from the current height, until ctx is done or
+// count blocks were committed (count 0 for no limit)
+func (p *ChannelPeer) Follow(ctx context.Context, channel string, orderer OrdererClient, count int) error {
+	blocks, _, err := p.Blocks(channel, 0)
+	if err != nil {
+		return err
+	}
+	stream, err := orderer.Deliver(ctx, uint64(len(blocks)))
+	if err != nil {
+		return err
+	}
+	for committed := 0; count == 0 || committed < count; committed++ {
+		block, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := p.Commit(channel, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exampleChannelConfig is a channel of some of the organisations in sks, all of which endorse
+func exampleChannelConfig(channel string, sks map[string]*blschia.PrivateKey, orgs []string, orderer_sk *blschia.PrivateKey) *ChannelConfig {
+	config := &ChannelConfig{ChannelID: channel, Members: make(map[string]*blschia.G1Element), Endorsers: orgs}
+	for _, name := range orgs {
+		config.Members[name], _ = sks[name].G1Element()
+	}
+	config.Orderer, _ = orderer_sk.G1Element()
+	return config
+}
+
+// Two channels sharing NPCI with their own orderers, a peer on both and a peer on one. Transactions and blocks only
+// verify on their own channel.
+func MultiChannelExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	sks := make(map[string]*blschia.PrivateKey)
+	for _, name := range []string{"NPCI", "RBI", "SBI", "HDFC", "ICICI", "Axis"} {
+		seed, _ := makeRandomArray(32)
+		sks[name], _ = scheme.KeyGen(seed)
+	}
+	channels := map[string][]string{
+		"upi-p2p":      {"NPCI", "RBI", "SBI", "HDFC"},
+		"upi-merchant": {"NPCI", "ICICI", "Axis"},
+	}
+
+	configs := make(map[string]*ChannelConfig)
+	orderers := make(map[string]OrdererClient)
+	for channel, orgs := range channels {
+		orderer_seed, _ := makeRandomArray(32)
+		orderer_sk, _ := scheme.KeyGen(orderer_seed)
+		configs[channel] = exampleChannelConfig(channel, sks, orgs, orderer_sk)
+		listener, err := ListenWire("127.0.0.1:0", nil)
+		if err != nil {
+			panic(err)
+		}
+		defer listener.Close()
+		go ServeWireOrderer(listener, NewOrderer(scheme, configs[channel], orderer_sk, OrdererConfig{MaxTransactions: 2, BatchTimeout: 50 * time.Millisecond}))
+		if orderers[channel], err = NewWireOrdererClient(listener.Addr().String(), nil); err != nil {
+			panic(err)
+		}
+	}
+
+	both, merchant := NewChannelPeer(scheme), NewChannelPeer(scheme)
+	for _, config := range configs {
+		if err := both.Join(config); err != nil {
+			panic(err)
+		}
+	}
+	if err := merchant.Join(configs["upi-merchant"]); err != nil {
+		panic(err)
+	}
+
+	txs := make(map[string][]*Transaction)
+	for channel, config := range configs {
+		for i := 0; i < 4; i++ {
+			proposal := NewProposalEnvelope(channel, examplePayment("SBI", "HDFC", uint64(100_00*(i+1))).Bytes(), time.Minute).Bytes()
+			tx := exampleEndorse(scheme, config, sks, proposal)
+			if err := orderers[channel].Broadcast(context.Background(), tx); err != nil {
+				panic(err)
+			}
+			txs[channel] = append(txs[channel], tx)
+		}
+	}
+	// A p2p transaction sent to the merchant channel's orderer is for another channel and endorsed by other organisations
+	if err := orderers["upi-merchant"].Broadcast(context.Background(), txs["upi-p2p"][0]); err == nil {
+		panic("merchant orderer accepted a p2p transaction")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	follow := func(peer *ChannelPeer, channel string) {
+		defer wg.Done()
+		errs <- peer.Follow(ctx, channel, orderers[channel], 2)
+	}
+	wg.Add(3)
+	go follow(both, "upi-p2p")
+	go follow(both, "upi-merchant")
+	go follow(merchant, "upi-merchant")
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for _, tx := range txs["upi-p2p"] {
+		if err := both.VerifyTransaction(tx); err != nil {
+			panic(err)
+		}
+		if err := merchant.VerifyTransaction(tx); !errors.Is(err, ErrUnknownChannel) {
+			panic(fmt.Sprintf("peer verified a transaction of a channel it did not join: %v", err))
+		}
+	}
+	// The p2p ledger's next block cannot be filled from the merchant channel
+	merchantBlocks, _, _ := both.Blocks("upi-merchant", 0)
+	if both.Commit("upi-p2p", merchantBlocks[0]) == nil {
+		panic("committed a merchant block to the p2p ledger")
+	}
+	for _, channel := range both.Channels() {
+		blocks, _, _ := both.Blocks(channel, 0)
+		fmt.Printf("channel %s: %d members, %d blocks\n", channel, len(configs[channel].Members), len(blocks))
+	}
+}
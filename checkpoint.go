@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Checkpoints are the multi-level aggregation mentioned in the observations of the proposal examples (roll-ups).
+// The aggregate signatures of a range of blocks are added into a single signature, and the (pk, msg) pairs needed to verify it
+// are stored compactly: every distinct public key once in Keys and every distinct message once along with the indices of its signers.
+// Checkpoints of adjacent ranges can themselves be merged, giving checkpoints of checkpoints.
+
+// CheckpointMessage is a message signed by the keys at the Signers indices of Checkpoint.Keys
+type CheckpointMessage struct {
+	Message []byte
+	Signers []int
+}
+
+type Checkpoint struct {
+	First     uint64 // Number of the first block covered
+	Last      uint64 // Number of the last block covered
+	LastHash  []byte // Hash of the header of the last block (lets the next checkpoint chain onto this one)
+	Keys      []*blschia.G1Element
+	Messages  []CheckpointMessage
+	Signature *blschia.G2Element
+}
+
+var (
+	ErrCheckpointEmpty    = errors.New("checkpoint: no blocks")
+	ErrCheckpointChain    = errors.New("checkpoint: blocks or checkpoints are not consecutive")
+	ErrCheckpointUnsigned = errors.New("checkpoint: block is not signed")
+	ErrCheckpointConfig   = errors.New("checkpoint: blocks cross a configuration block")
+)
+
+type checkpointBuilder struct {
+	checkpoint *Checkpoint
+	keys       map[string]int
+	messages   map[string]int
+}
+
+func newCheckpointBuilder(first uint64) *checkpointBuilder {
+	return &checkpointBuilder{
+		checkpoint: &Checkpoint{First: first},
+		keys:       make(map[string]int),
+		messages:   make(map[string]int),
+	}
+}
+
+func (b *checkpointBuilder) add(pk *blschia.G1Element, msg []byte) {
+	serialized := string(pk.Serialize())
+	key, found := b.keys[serialized]
+	if !found {
+		key = len(b.checkpoint.Keys)
+		b.keys[serialized] = key
+		b.checkpoint.Keys = append(b.checkpoint.Keys, pk)
+	}
+	m, found := b.messages[string(msg)]
+	if !found {
+		m = len(b.checkpoint.Messages)
+		b.messages[string(msg)] = m
+		b.checkpoint.Messages = append(b.checkpoint.Messages, CheckpointMessage{Message: msg})
+	}
+	b.checkpoint.Messages[m].Signers = append(b.checkpoint.Messages[m].Signers, key)
+}
+
+// BuildCheckpoint aggregates a consecutive range of blocks. The blocks are assumed to have been verified already (e.g. by a full peer).
+// config must be the configuration the blocks were ordered under, so a configuration block may only end the range (the
+// admins of config signed its update, and the blocks after it are ordered under the next configuration).
+func BuildCheckpoint(scheme SignatureScheme, config *ChannelConfig, blocks []*Block) (*Checkpoint, error) {
+	if len(blocks) == 0 {
+		return nil, ErrCheckpointEmpty
+	}
+	endorsers, err := config.EndorserKeys()
+	if err != nil {
+		return nil, err
+	}
+	builder := newCheckpointBuilder(blocks[0].Header.Number)
+	sigs := make([]*blschia.G2Element, len(blocks))
+	for i, block := range blocks {
+		if block.AggregateSignature == nil {
+			return nil, ErrCheckpointUnsigned
+		}
+		if i > 0 && (block.Header.Number != blocks[i-1].Header.Number+1 || !bytes.Equal(block.Header.PreviousHash, blocks[i-1].Header.Hash())) {
+			return nil, ErrCheckpointChain
+		}
+		ordererPks, err := config.OrdererKeys(block.Signers)
+		if err != nil {
+			return nil, err
+		}
+		for _, pk := range ordererPks {
+			builder.add(pk, block.Header.Bytes())
+		}
+		if IsConfigBlock(block) {
+			if i != len(blocks)-1 {
+				return nil, ErrCheckpointConfig
+			}
+			t, err := DecodeConfigTransaction(block.Transactions[0])
+			if err != nil {
+				return nil, err
+			}
+			admins, err := config.AdminKeys(t.Signers)
+			if err != nil {
+				return nil, err
+			}
+			for _, pk := range admins {
+				builder.add(pk, t.Update.Bytes())
+			}
+			sigs[i] = block.AggregateSignature
+			continue
+		}
+		for _, tx := range block.Transactions {
+			for _, pk := range endorsers {
+				builder.add(pk, tx.Proposal)
+			}
+		}
+		sigs[i] = block.AggregateSignature
+	}
+	last := blocks[len(blocks)-1].Header
+	builder.checkpoint.Last = last.Number
+	builder.checkpoint.LastHash = last.Hash()
+	builder.checkpoint.Signature = scheme.AggregateSigs(sigs...)
+	return builder.checkpoint, nil
+}
+
+// header returns the block header for number among the messages of the checkpoint. Its signers are not checked.
+func (cp *Checkpoint) header(number uint64) *BlockHeader {
+	for _, m := range cp.Messages {
+		if header, ok := decodeHeaderBytes(m.Message); ok && header.Number == number {
+			return header
+		}
+	}
+	return nil
+}
+
+// MergeCheckpoints rolls up checkpoints over consecutive ranges into one.
+// The first header of every checkpoint must chain onto the last header of the previous one.
+func MergeCheckpoints(scheme SignatureScheme, checkpoints ...*Checkpoint) (*Checkpoint, error) {
+	if len(checkpoints) == 0 {
+		return nil, ErrCheckpointEmpty
+	}
+	builder := newCheckpointBuilder(checkpoints[0].First)
+	sigs := make([]*blschia.G2Element, len(checkpoints))
+	for i, cp := range checkpoints {
+		if i > 0 {
+			previous := checkpoints[i-1]
+			first := cp.header(cp.First)
+			if cp.First != previous.Last+1 || first == nil || !bytes.Equal(first.PreviousHash, previous.LastHash) {
+				return nil, ErrCheckpointChain
+			}
+		}
+		for _, m := range cp.Messages {
+			for _, signer := range m.Signers {
+				if signer < 0 || signer >= len(cp.Keys) {
+					return nil, fmt.Errorf("checkpoint: signer index %d out of range", signer)
+				}
+				builder.add(cp.Keys[signer], m.Message)
+			}
+		}
+		sigs[i] = cp.Signature
+	}
+	last := checkpoints[len(checkpoints)-1]
+	builder.checkpoint.Last = last.Last
+	builder.checkpoint.LastHash = last.LastHash
+	builder.checkpoint.Signature = scheme.AggregateSigs(sigs...)
+	return builder.checkpoint, nil
+}
+
+// Verify checks the checkpoint signature in one AggregateVerify.
+// With the proof of possession scheme the public keys of all the signers of a message are aggregated first,
+// so there is one pairing per distinct message instead of one per (pk, msg) pair.
+// Every key must be a member, the orderer or a consenter of the channel configuration, and the checkpoint must hold a
+// header signed by the orderer (or a quorum of consenters) for every block from First to Last, each chained onto the one
+// before and the last one hashing to LastHash.
+func (cp *Checkpoint) Verify(scheme SignatureScheme, config *ChannelConfig) error {
+	if len(cp.Messages) == 0 {
+		return ErrCheckpointEmpty
+	}
+	if cp.Last < cp.First {
+		return ErrCheckpointChain
+	}
+	trusted := make(map[string]bool)
+	if config.Orderer != nil {
+		trusted[string(config.Orderer.Serialize())] = true
+	}
+	for _, pk := range config.Consenters {
+		trusted[string(pk.Serialize())] = true
+	}
+	for _, pk := range config.Members {
+		trusted[string(pk.Serialize())] = true
+	}
+	for _, pk := range cp.Keys {
+		if !trusted[string(pk.Serialize())] {
+			return fmt.Errorf("checkpoint: key %s is not part of the channel", pk.HexString())
+		}
+	}
+	headers := make(map[uint64]*BlockHeader)
+	var pks []*blschia.G1Element
+	var msgs [][]byte
+	pop, isPop := scheme.(*blschia.PopSchemeMPL)
+	for _, m := range cp.Messages {
+		if len(m.Signers) == 0 {
+			return errors.New("checkpoint: message without signers")
+		}
+		signers := make([]*blschia.G1Element, len(m.Signers))
+		for i, signer := range m.Signers {
+			if signer < 0 || signer >= len(cp.Keys) {
+				return fmt.Errorf("checkpoint: signer index %d out of range", signer)
+			}
+			signers[i] = cp.Keys[signer]
+		}
+		if header, ok := decodeHeaderBytes(m.Message); ok && headerSigned(config, signers) {
+			if header.Number < cp.First || header.Number > cp.Last || headers[header.Number] != nil {
+				return ErrCheckpointChain
+			}
+			headers[header.Number] = header
+		}
+		if isPop {
+			pks = append(pks, pop.AggregatePubKeys(signers...))
+			msgs = append(msgs, m.Message)
+			continue
+		}
+		for _, pk := range signers {
+			pks = append(pks, pk)
+			msgs = append(msgs, m.Message)
+		}
+	}
+	// The signed headers are distinct and within the range, so there is one for every block when there are as many as blocks
+	if len(headers) == 0 || uint64(len(headers)-1) != cp.Last-cp.First {
+		return fmt.Errorf("%w: %d signed headers for blocks %d to %d", ErrCheckpointUnsigned, len(headers), cp.First, cp.Last)
+	}
+	for n := cp.First; n < cp.Last; n++ {
+		if !bytes.Equal(headers[n+1].PreviousHash, headers[n].Hash()) {
+			return ErrCheckpointChain
+		}
+	}
+	if !bytes.Equal(headers[cp.Last].Hash(), cp.LastHash) {
+		return ErrCheckpointChain
+	}
+	if cp.Signature == nil || !scheme.AggregateVerify(pks, msgs, cp.Signature) {
+		return errors.New("checkpoint: invalid aggregate signature")
+	}
+	return nil
+}
+
+// headerSigned reports whether signers include the keys that sign the block headers of the channel: the orderer, or a
+// quorum of distinct consenters when the channel is ordered by a cluster
+func headerSigned(config *ChannelConfig, signers []*blschia.G1Element) bool {
+	consenters := make(map[int]bool)
+	for _, pk := range signers {
+		if len(config.Consenters) == 0 && pk.EqualTo(config.Orderer) {
+			return true
+		}
+		for i, consenter := range config.Consenters {
+			if pk.EqualTo(consenter) {
+				consenters[i] = true
+			}
+		}
+	}
+	return len(config.Consenters) > 0 && len(consenters) >= config.ConsenterQuorum
+}
+
+// Size is the number of bytes needed to ship the checkpoint (4 bytes per length or index)
+func (cp *Checkpoint) Size() int {
+	size := 8 + 8 + len(cp.LastHash) + 96 + 4 + 48*len(cp.Keys) + 4
+	for _, m := range cp.Messages {
+		size += 4 + len(m.Message) + 4 + 4*len(m.Signers)
+	}
+	return size
+}
+
+// Two levels of roll-ups: checkpoints of 4 blocks each rolled up into a single checkpoint of 8 blocks
+func CheckpointExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	blocks := exampleChain(scheme, config, sks, orderer_sk, 8, 2)
+
+	first, err := BuildCheckpoint(scheme, config, blocks[:4])
+	if err != nil {
+		panic(err)
+	}
+	second, err := BuildCheckpoint(scheme, config, blocks[4:])
+	if err != nil {
+		panic(err)
+	}
+	rollup, err := MergeCheckpoints(scheme, first, second)
+	if err != nil {
+		panic(err)
+	}
+	for _, cp := range []*Checkpoint{first, second, rollup} {
+		if err := cp.Verify(scheme, config); err != nil {
+			panic(err)
+		}
+	}
+
+	blockSize := 0
+	for _, block := range blocks {
+		blockSize += 96
+		for _, tx := range block.Transactions {
+			blockSize += len(tx.Proposal) + 96
+		}
+	}
+	fmt.Println("Blocks: ", len(blocks), " checkpoint size: ", rollup.Size(), " per block signatures and payloads size: ", blockSize)
+
+	// Rewriting the range of a checkpoint invalidates it, as does tampering with any descriptor of the roll-up
+	second.First, second.Last = 0, 3
+	if second.Verify(scheme, config) == nil {
+		panic("checkpoint with a rewritten range verified")
+	}
+	tampered := append([]byte{}, rollup.Messages[1].Message...)
+	tampered[0] ^= 1
+	rollup.Messages[1].Message = tampered
+	if rollup.Verify(scheme, config) == nil {
+		panic("tampered checkpoint verified")
+	}
+}
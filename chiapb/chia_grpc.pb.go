@@ -0,0 +1,270 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: chia.proto
+
+// Wire format of the endorse-order-commit flow. Public keys and signatures are carried as the serialized
+// blschia G1Element (48 bytes) and G2Element (96 bytes) respectively.
+
+package chiapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Endorser_Endorse_FullMethodName = "/chia.Endorser/Endorse"
+)
+
+// EndorserClient is the client API for Endorser service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EndorserClient interface {
+	Endorse(ctx context.Context, in *Proposal, opts ...grpc.CallOption) (*Endorsement, error)
+}
+
+type endorserClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEndorserClient(cc grpc.ClientConnInterface) EndorserClient {
+	return &endorserClient{cc}
+}
+
+func (c *endorserClient) Endorse(ctx context.Context, in *Proposal, opts ...grpc.CallOption) (*Endorsement, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Endorsement)
+	err := c.cc.Invoke(ctx, Endorser_Endorse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EndorserServer is the server API for Endorser service.
+// All implementations must embed UnimplementedEndorserServer
+// for forward compatibility
+type EndorserServer interface {
+	Endorse(context.Context, *Proposal) (*Endorsement, error)
+	mustEmbedUnimplementedEndorserServer()
+}
+
+// UnimplementedEndorserServer must be embedded to have forward compatible implementations.
+type UnimplementedEndorserServer struct {
+}
+
+func (UnimplementedEndorserServer) Endorse(context.Context, *Proposal) (*Endorsement, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Endorse not implemented")
+}
+func (UnimplementedEndorserServer) mustEmbedUnimplementedEndorserServer() {}
+
+// UnsafeEndorserServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EndorserServer will
+// result in compilation errors.
+type UnsafeEndorserServer interface {
+	mustEmbedUnimplementedEndorserServer()
+}
+
+func RegisterEndorserServer(s grpc.ServiceRegistrar, srv EndorserServer) {
+	s.RegisterService(&Endorser_ServiceDesc, srv)
+}
+
+func _Endorser_Endorse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Proposal)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EndorserServer).Endorse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Endorser_Endorse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EndorserServer).Endorse(ctx, req.(*Proposal))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Endorser_ServiceDesc is the grpc.ServiceDesc for Endorser service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Endorser_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chia.Endorser",
+	HandlerType: (*EndorserServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Endorse",
+			Handler:    _Endorser_Endorse_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "chia.proto",
+}
+
+const (
+	Orderer_Broadcast_FullMethodName = "/chia.Orderer/Broadcast"
+	Orderer_Deliver_FullMethodName   = "/chia.Orderer/Deliver"
+)
+
+// OrdererClient is the client API for Orderer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OrdererClient interface {
+	Broadcast(ctx context.Context, in *Transaction, opts ...grpc.CallOption) (*BroadcastResponse, error)
+	Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (Orderer_DeliverClient, error)
+}
+
+type ordererClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOrdererClient(cc grpc.ClientConnInterface) OrdererClient {
+	return &ordererClient{cc}
+}
+
+func (c *ordererClient) Broadcast(ctx context.Context, in *Transaction, opts ...grpc.CallOption) (*BroadcastResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BroadcastResponse)
+	err := c.cc.Invoke(ctx, Orderer_Broadcast_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ordererClient) Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (Orderer_DeliverClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Orderer_ServiceDesc.Streams[0], Orderer_Deliver_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ordererDeliverClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Orderer_DeliverClient interface {
+	Recv() (*Block, error)
+	grpc.ClientStream
+}
+
+type ordererDeliverClient struct {
+	grpc.ClientStream
+}
+
+func (x *ordererDeliverClient) Recv() (*Block, error) {
+	m := new(Block)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// OrdererServer is the server API for Orderer service.
+// All implementations must embed UnimplementedOrdererServer
+// for forward compatibility
+type OrdererServer interface {
+	Broadcast(context.Context, *Transaction) (*BroadcastResponse, error)
+	Deliver(*DeliverRequest, Orderer_DeliverServer) error
+	mustEmbedUnimplementedOrdererServer()
+}
+
+// UnimplementedOrdererServer must be embedded to have forward compatible implementations.
+type UnimplementedOrdererServer struct {
+}
+
+func (UnimplementedOrdererServer) Broadcast(context.Context, *Transaction) (*BroadcastResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Broadcast not implemented")
+}
+func (UnimplementedOrdererServer) Deliver(*DeliverRequest, Orderer_DeliverServer) error {
+	return status.Errorf(codes.Unimplemented, "method Deliver not implemented")
+}
+func (UnimplementedOrdererServer) mustEmbedUnimplementedOrdererServer() {}
+
+// UnsafeOrdererServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OrdererServer will
+// result in compilation errors.
+type UnsafeOrdererServer interface {
+	mustEmbedUnimplementedOrdererServer()
+}
+
+func RegisterOrdererServer(s grpc.ServiceRegistrar, srv OrdererServer) {
+	s.RegisterService(&Orderer_ServiceDesc, srv)
+}
+
+func _Orderer_Broadcast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Transaction)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrdererServer).Broadcast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orderer_Broadcast_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrdererServer).Broadcast(ctx, req.(*Transaction))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orderer_Deliver_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DeliverRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrdererServer).Deliver(m, &ordererDeliverServer{ServerStream: stream})
+}
+
+type Orderer_DeliverServer interface {
+	Send(*Block) error
+	grpc.ServerStream
+}
+
+type ordererDeliverServer struct {
+	grpc.ServerStream
+}
+
+func (x *ordererDeliverServer) Send(m *Block) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Orderer_ServiceDesc is the grpc.ServiceDesc for Orderer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Orderer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chia.Orderer",
+	HandlerType: (*OrdererServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Broadcast",
+			Handler:    _Orderer_Broadcast_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Deliver",
+			Handler:       _Orderer_Deliver_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "chia.proto",
+}
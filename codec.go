@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Text and document encodings of secret keys (32 bytes), G1Elements (48 bytes) and G2Elements (96 bytes). Hex and
+// base64 encode the serialization alone. JSON and CBOR documents add the curve, the kind of value and the scheme it is
+// used with, so a key or signature is not taken for one of another scheme. Decoding validates the value: points must be
+// canonical compressed encodings of points in the subgroup other than the point at infinity, and secret keys non-zero and
+// less than the group order. Decoders only accept the encoding the encoder produces, e.g. lowercase hex.
+
+// BLSValue is a value the codecs handle
+type BLSValue interface {
+	*blschia.PrivateKey | *blschia.G1Element | *blschia.G2Element
+}
+
+const BLSCurve = "BLS12-381"
+
+// Scheme names of the JSON and CBOR metadata
+const (
+	SchemeBasic = "basic"
+	SchemeAug   = "aug"
+	SchemePop   = "pop"
+)
+
+var (
+	ErrBLSEncoding   = errors.New("codec: malformed encoding")
+	ErrInvalidPoint  = errors.New("codec: invalid point")
+	ErrInfinityPoint = errors.New("codec: point at infinity")
+	ErrInvalidKey    = errors.New("codec: invalid secret key")
+	ErrBLSMetadata   = errors.New("codec: unexpected curve, type or scheme")
+)
+
+// Flag bit of the compressed encoding marking the point at infinity
+const infinityFlag = 0x40
+
+// SchemeName is the metadata name of scheme, "" for schemes without one
+func SchemeName(scheme SignatureScheme) string {
+	switch scheme.(type) {
+	case *blschia.BasicSchemeMPL:
+		return SchemeBasic
+	case *blschia.AugSchemeMPL:
+		return SchemeAug
+	case *blschia.PopSchemeMPL:
+		return SchemePop
+	}
+	return ""
+}
+
+func knownScheme(name string) bool {
+	return name == SchemeBasic || name == SchemeAug || name == SchemePop
+}
+
+// blsKind is the metadata type and serialized size of T
+func blsKind[T BLSValue]() (string, int) {
+	var v T
+	switch any(v).(type) {
+	case *blschia.PrivateKey:
+		return "secret-key", 32
+	case *blschia.G1Element:
+		return "g1", 48
+	default:
+		return "g2", 96
+	}
+}
+
+func BLSBytes[T BLSValue](v T) []byte {
+	switch v := any(v).(type) {
+	case *blschia.PrivateKey:
+		return v.Serialize()
+	case *blschia.G1Element:
+		return v.Serialize()
+	default:
+		return v.(*blschia.G2Element).Serialize()
+	}
+}
+
+// ParseBLSBytes parses and validates a serialized secret key, G1Element or G2Element
+func ParseBLSBytes[T BLSValue](data []byte) (T, error) {
+	var zero T
+	kind, size := blsKind[T]()
+	if len(data) != size {
+		return zero, fmt.Errorf("%w: %d bytes for %s", ErrBLSEncoding, len(data), kind)
+	}
+	var v any
+	var err error
+	switch any(zero).(type) {
+	case *blschia.PrivateKey:
+		if bytes.Equal(data, make([]byte, size)) {
+			return zero, ErrInvalidKey
+		}
+		if v, err = blschia.PrivateKeyFromBytes(data, false); err != nil {
+			return zero, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+	case *blschia.G1Element:
+		if data[0]&infinityFlag != 0 {
+			return zero, ErrInfinityPoint
+		}
+		if v, err = blschia.G1ElementFromBytes(data); err != nil {
+			return zero, fmt.Errorf("%w: %v", ErrInvalidPoint, err)
+		}
+	default:
+		if data[0]&infinityFlag != 0 {
+			return zero, ErrInfinityPoint
+		}
+		if v, err = blschia.G2ElementFromBytes(data); err != nil {
+			return zero, fmt.Errorf("%w: %v", ErrInvalidPoint, err)
+		}
+	}
+	if !bytes.Equal(BLSBytes(v.(T)), data) {
+		return zero, fmt.Errorf("%w: non-canonical %s", ErrBLSEncoding, kind)
+	}
+	return v.(T), nil
+}
+
+func BLSHex[T BLSValue](v T) string {
+	return hex.EncodeToString(BLSBytes(v))
+}
+
+func ParseBLSHex[T BLSValue](s string) (T, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || hex.EncodeToString(data) != s {
+		var zero T
+		return zero, ErrBLSEncoding
+	}
+	return ParseBLSBytes[T](data)
+}
+
+// BLSBase64 uses standard padded base64
+func BLSBase64[T BLSValue](v T) string {
+	return base64.StdEncoding.EncodeToString(BLSBytes(v))
+}
+
+func ParseBLSBase64[T BLSValue](s string) (T, error) {
+	data, err := base64.StdEncoding.Strict().DecodeString(s)
+	if err != nil || base64.StdEncoding.EncodeToString(data) != s {
+		var zero T
+		return zero, ErrBLSEncoding
+	}
+	return ParseBLSBytes[T](data)
+}
+
+// blsDocument is the JSON document, with a hex value, and the CBOR document, a map with integer keys and a byte string value
+type blsDocument struct {
+	Curve  string `json:"curve" cbor:"1,keyasint"`
+	Type   string `json:"type" cbor:"2,keyasint"`
+	Scheme string `json:"scheme" cbor:"3,keyasint"`
+	Value  string `json:"value" cbor:"-"`
+	Bytes  []byte `json:"-" cbor:"4,keyasint"`
+}
+
+func newBLSDocument[T BLSValue](v T, scheme string) (*blsDocument, error) {
+	if !knownScheme(scheme) {
+		return nil, fmt.Errorf("%w: scheme %q", ErrBLSMetadata, scheme)
+	}
+	kind, _ := blsKind[T]()
+	return &blsDocument{Curve: BLSCurve, Type: kind, Scheme: scheme}, nil
+}
+
+func (d *blsDocument) check(kind string) error {
+	if d.Curve != BLSCurve || d.Type != kind || !knownScheme(d.Scheme) {
+		return fmt.Errorf("%w: %s %s of scheme %q", ErrBLSMetadata, d.Curve, d.Type, d.Scheme)
+	}
+	return nil
+}
+
+// BLSJSON encodes v with the name of the scheme it belongs to
+func BLSJSON[T BLSValue](v T, scheme string) ([]byte, error) {
+	d, err := newBLSDocument(v, scheme)
+	if err != nil {
+		return nil, err
+	}
+	d.Value = BLSHex(v)
+	return json.Marshal(d)
+}
+
+// ParseBLSJSON returns the value of a JSON document and the name of its scheme
+func ParseBLSJSON[T BLSValue](data []byte) (T, string, error) {
+	var zero T
+	var d blsDocument
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&d); err != nil {
+		return zero, "", fmt.Errorf("%w: %v", ErrBLSEncoding, err)
+	}
+	kind, _ := blsKind[T]()
+	if err := d.check(kind); err != nil {
+		return zero, "", err
+	}
+	v, err := ParseBLSHex[T](d.Value)
+	if err != nil {
+		return zero, "", err
+	}
+	if canonical, _ := BLSJSON(v, d.Scheme); !bytes.Equal(canonical, data) {
+		return zero, "", fmt.Errorf("%w: non-canonical JSON", ErrBLSEncoding)
+	}
+	return v, d.Scheme, nil
+}
+
+var (
+	blsCBOREncoder, _ = cbor.CoreDetEncOptions().EncMode()
+	blsCBORDecoder, _ = cbor.DecOptions{
+		DupMapKey:         cbor.DupMapKeyEnforcedAPF,
+		IndefLength:       cbor.IndefLengthForbidden,
+		TagsMd:            cbor.TagsForbidden,
+		ExtraReturnErrors: cbor.ExtraDecErrorUnknownField,
+	}.DecMode()
+)
+
+// BLSCBOR encodes v with the name of the scheme it belongs to, in core deterministic CBOR
+func BLSCBOR[T BLSValue](v T, scheme string) ([]byte, error) {
+	d, err := newBLSDocument(v, scheme)
+	if err != nil {
+		return nil, err
+	}
+	d.Bytes = BLSBytes(v)
+	return blsCBOREncoder.Marshal(d)
+}
+
+// ParseBLSCBOR returns the value of a CBOR document and the name of its scheme
+func ParseBLSCBOR[T BLSValue](data []byte) (T, string, error) {
+	var zero T
+	var d blsDocument
+	if err := blsCBORDecoder.Unmarshal(data, &d); err != nil {
+		return zero, "", fmt.Errorf("%w: %v", ErrBLSEncoding, err)
+	}
+	kind, _ := blsKind[T]()
+	if err := d.check(kind); err != nil {
+		return zero, "", err
+	}
+	v, err := ParseBLSBytes[T](d.Bytes)
+	if err != nil {
+		return zero, "", err
+	}
+	if canonical, _ := BLSCBOR(v, d.Scheme); !bytes.Equal(canonical, data) {
+		return zero, "", fmt.Errorf("%w: non-canonical CBOR", ErrBLSEncoding)
+	}
+	return v, d.Scheme, nil
+}
+
+// A key pair and a signature through every encoding, and the encodings decoding refuses
+func CodecExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	seed, _ := makeRandomArray(32)
+	sk, _ := scheme.KeyGen(seed)
+	pk, _ := sk.G1Element()
+	msg := examplePayment("SBI", "HDFC", 1500_00).Bytes()
+	sig := scheme.Sign(sk, msg)
+	name := SchemeName(scheme)
+
+	pk_json, _ := BLSJSON(pk, name)
+	sig_cbor, _ := BLSCBOR(sig, name)
+	sk_hex := BLSHex(sk)
+	decoded_sk, err := ParseBLSHex[*blschia.PrivateKey](sk_hex)
+	if err != nil || !decoded_sk.EqualTo(sk) {
+		panic(fmt.Sprintf("secret key did not round trip: %v", err))
+	}
+	decoded_pk, pk_scheme, err := ParseBLSJSON[*blschia.G1Element](pk_json)
+	if err != nil || pk_scheme != name || !decoded_pk.EqualTo(pk) {
+		panic(fmt.Sprintf("public key did not round trip: %v", err))
+	}
+	decoded_sig, _, err := ParseBLSCBOR[*blschia.G2Element](sig_cbor)
+	if err != nil || !decoded_sig.EqualTo(sig) {
+		panic(fmt.Sprintf("signature did not round trip: %v", err))
+	}
+	if decoded_sig, err = ParseBLSBase64[*blschia.G2Element](BLSBase64(sig)); err != nil || !scheme.Verify(decoded_pk, msg, decoded_sig) {
+		panic(fmt.Sprintf("signature did not round trip: %v", err))
+	}
+
+	infinity := make([]byte, 48)
+	infinity[0] = 0xc0
+	if _, err := ParseBLSBytes[*blschia.G1Element](infinity); !errors.Is(err, ErrInfinityPoint) {
+		panic("decoded the point at infinity as a public key")
+	}
+	invalid := bytes.Repeat([]byte{0xff}, 96)
+	invalid[0] = 0x9f
+	if _, err := ParseBLSBytes[*blschia.G2Element](invalid); !errors.Is(err, ErrInvalidPoint) {
+		panic("decoded an invalid signature")
+	}
+	if _, err := ParseBLSHex[*blschia.G1Element](BLSHex(sig)); !errors.Is(err, ErrBLSEncoding) {
+		panic("decoded a signature as a public key")
+	}
+	if _, _, err := ParseBLSJSON[*blschia.G2Element](pk_json); !errors.Is(err, ErrBLSMetadata) {
+		panic("decoded a public key document as a signature")
+	}
+	fmt.Printf("codec: public key %d bytes as JSON, signature %d bytes as CBOR, %d as base64\n", len(pk_json), len(sig_cbor), len(BLSBase64(sig)))
+	fmt.Println(string(pk_json))
+}
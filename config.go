@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// ChannelConfig is the membership and policy information a verifier needs: who the orderer is,
+// the public key of every member organisation and which organisations must endorse a transaction.
+type ChannelConfig struct {
+	Orderer *blschia.G1Element
+	Members map[string]*blschia.G1Element
+	// Endorsement policy: every listed organisation endorses every transaction (in this order when aggregating)
+	Endorsers []string
+	// Ordering cluster. When set, blocks are signed by the consenters instead of Orderer and list the signing
+	// consenters in Block.Signers, of which there must be at least ConsenterQuorum.
+	Consenters      []*blschia.G1Element
+	ConsenterQuorum int
+	// Channel the endorsements are bound to. When set, every proposal must be a ProposalEnvelope for this channel.
+	ChannelID string
+	// Incremented by every configuration transaction, which at least AdminQuorum of Admins sign (every admin if 0)
+	Version     uint64
+	Admins      []string
+	AdminQuorum int
+	// Keys that no longer verify from a block height on (see revocation.go)
+	Revoked []Revocation
+}
+
+// OrdererKeys resolves the signers of a block header to public keys, checking the quorum when there is an ordering cluster
+func (c *ChannelConfig) OrdererKeys(signers SignerBitmap) ([]*blschia.G1Element, error) {
+	if len(c.Consenters) == 0 {
+		if len(signers) != 0 {
+			return nil, fmt.Errorf("config: block lists consenters but the channel has a single orderer")
+		}
+		return []*blschia.G1Element{c.Orderer}, nil
+	}
+	if len(signers) != len(NewSignerBitmap(len(c.Consenters))) {
+		return nil, fmt.Errorf("config: signer bitmap of %d bytes for %d consenters", len(signers), len(c.Consenters))
+	}
+	indices := signers.Indices()
+	if len(indices) > 0 && indices[len(indices)-1] >= len(c.Consenters) {
+		return nil, fmt.Errorf("config: unknown consenter %d", indices[len(indices)-1])
+	}
+	if len(indices) < c.ConsenterQuorum {
+		return nil, fmt.Errorf("config: %d consenters signed but the quorum is %d", len(indices), c.ConsenterQuorum)
+	}
+	pks := make([]*blschia.G1Element, len(indices))
+	for i, signer := range indices {
+		pks[i] = c.Consenters[signer]
+	}
+	return pks, nil
+}
+
+// EndorserKeys resolves the endorsement policy to public keys
+func (c *ChannelConfig) EndorserKeys() ([]*blschia.G1Element, error) {
+	pks := make([]*blschia.G1Element, len(c.Endorsers))
+	for i, name := range c.Endorsers {
+		pk, found := c.Members[name]
+		if !found {
+			return nil, fmt.Errorf("config: endorser %s is not a channel member", name)
+		}
+		pks[i] = pk
+	}
+	return pks, nil
+}
+
+// VerifyEndorsement checks a transaction's aggregate endorsement against the endorsement policy
+func (c *ChannelConfig) VerifyEndorsement(scheme SignatureScheme, tx *Transaction) bool {
+	pks, err := c.EndorserKeys()
+	if err != nil || len(pks) == 0 || tx.Endorsement == nil || !c.ownsProposal(tx.Proposal) {
+		return false
+	}
+	msgs := make([][]byte, len(pks))
+	for i := range msgs {
+		msgs[i] = tx.Proposal
+	}
+	return scheme.AggregateVerify(pks, msgs, tx.Endorsement)
+}
+
+// VerifyBlock runs Block.Verify with the keys of this configuration, or checks the update and admin signatures of a
+// configuration block
+func (c *ChannelConfig) VerifyBlock(scheme SignatureScheme, block *Block) bool {
+	ordererPks, err := c.OrdererKeys(block.Signers)
+	if err != nil {
+		return false
+	}
+	if c.CheckRevoked(ordererPks, block.Header.Number) != nil {
+		return false
+	}
+	if IsConfigBlock(block) {
+		return c.verifyConfigBlock(scheme, ordererPks, block)
+	}
+	pks, err := c.EndorserKeys()
+	if err != nil || c.CheckRevoked(pks, block.Header.Number) != nil {
+		return false
+	}
+	for _, tx := range block.Transactions {
+		if !c.ownsProposal(tx.Proposal) {
+			return false
+		}
+	}
+	return block.Verify(scheme, ordererPks, pks)
+}
+
+// exampleOrganisations are the endorsing organisations used throughout the examples
+var exampleOrganisations = []string{"NPCI", "RBI", "SBI", "HDFC"}
+
+// exampleChannel generates fresh keys for the example organisations and the orderer
+func exampleChannel(scheme blschia.Scheme) (*ChannelConfig, map[string]*blschia.PrivateKey, *blschia.PrivateKey) {
+	config := &ChannelConfig{Members: make(map[string]*blschia.G1Element), Endorsers: exampleOrganisations}
+	sks := make(map[string]*blschia.PrivateKey)
+	for _, name := range exampleOrganisations {
+		seed, _ := makeRandomArray(32)
+		sk, _ := scheme.KeyGen(seed)
+		sks[name] = sk
+		config.Members[name], _ = sk.G1Element()
+	}
+	orderer_seed, _ := makeRandomArray(32)
+	orderer_sk, _ := scheme.KeyGen(orderer_seed)
+	config.Orderer, _ = orderer_sk.G1Element()
+	return config, sks, orderer_sk
+}
+
+// exampleEndorse collects and aggregates the endorsements of every endorser in the policy
+func exampleEndorse(scheme SignatureScheme, config *ChannelConfig, sks map[string]*blschia.PrivateKey, proposal []byte) *Transaction {
+	sigs := make([]*blschia.G2Element, len(config.Endorsers))
+	for i, name := range config.Endorsers {
+		sigs[i] = scheme.Sign(sks[name], proposal)
+	}
+	return &Transaction{Proposal: proposal, Endorsement: scheme.AggregateSigs(sigs...)}
+}
+
+// exampleChain produces count signed blocks of txsPerBlock transactions each
+func exampleChain(scheme SignatureScheme, config *ChannelConfig, sks map[string]*blschia.PrivateKey, orderer_sk *blschia.PrivateKey, count, txsPerBlock int) []*Block {
+	blocks := make([]*Block, 0, count)
+	var previous *BlockHeader
+	for b := 0; b < count; b++ {
+		txs := make([]*Transaction, txsPerBlock)
+		for i := range txs {
+			proposal, _ := makeRandomArray(5000)
+			txs[i] = exampleEndorse(scheme, config, sks, proposal)
+		}
+		block := NewBlock(previous, txs)
+		block.Sign(scheme, orderer_sk)
+		blocks = append(blocks, block)
+		previous = &block.Header
+	}
+	return blocks
+}
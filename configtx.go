@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Configuration transactions. A ConfigUpdate changes the membership, keys or policies of a channel and is signed by a
+// quorum of the channel admins with one aggregate signature, the signing admins listed in a SignerBitmap over
+// ChannelConfig.Admins. New and rotated keys come with a proof of possession so that nobody can register a rogue key.
+// The orderer puts every configuration transaction in a block of its own, and peers apply it once the block is
+// committed, so the transactions of every later block are checked against the new configuration version while
+// earlier blocks stay valid under the version they were ordered with.
+
+const (
+	configUpdateTag      = "chia/config-update/v1/"
+	configTransactionTag = "config-transaction"
+)
+
+var (
+	ErrConfigVersion     = errors.New("configtx: update is not for the current configuration version")
+	ErrConfigSignature   = errors.New("configtx: update is not signed by the admin policy")
+	ErrConfigPoP         = errors.New("configtx: invalid proof of possession")
+	ErrInvalidConfig     = errors.New("configtx: update results in an invalid configuration")
+	ErrMalformedConfigTx = errors.New("configtx: malformed configuration transaction")
+)
+
+type ConfigOp uint8
+
+const (
+	ConfigAddOrg       ConfigOp = iota + 1 // Org, Key and PoP
+	ConfigRemoveOrg                        // Org
+	ConfigRotateKey                        // Org, Key and PoP
+	ConfigSetEndorsers                     // Orgs
+	ConfigSetAdmins                        // Orgs and Quorum
+	ConfigRevokeKey                        // Key and Height
+)
+
+type ConfigChange struct {
+	Op     ConfigOp
+	Org    string
+	Key    *blschia.G1Element
+	PoP    *blschia.G2Element // Proof of possession of Key under PopSchemeMPL
+	Orgs   []string
+	Quorum int
+	Height uint64 // First block the revoked key is rejected in
+}
+
+type ConfigUpdate struct {
+	Channel string
+	Version uint64 // Configuration version the update applies to
+	Changes []ConfigChange
+}
+
+// Bytes is the message the admins sign. It starts with a tag for the channel so updates are bound to it.
+func (u *ConfigUpdate) Bytes() []byte {
+	fields := [][]byte{[]byte(configUpdateTag + u.Channel), binary.BigEndian.AppendUint64(nil, u.Version)}
+	for _, change := range u.Changes {
+		var key, pop []byte
+		if change.Key != nil {
+			key = change.Key.Serialize()
+		}
+		if change.PoP != nil {
+			pop = change.PoP.Serialize()
+		}
+		orgs := make([][]byte, len(change.Orgs))
+		for i, org := range change.Orgs {
+			orgs[i] = []byte(org)
+		}
+		fields = append(fields, EncodeProposal([]byte{byte(change.Op)}, []byte(change.Org), key, pop, EncodeProposal(orgs...),
+			binary.BigEndian.AppendUint32(nil, uint32(change.Quorum)), binary.BigEndian.AppendUint64(nil, change.Height)))
+	}
+	return EncodeProposal(fields...)
+}
+
+func DecodeConfigUpdate(data []byte) (*ConfigUpdate, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 || !bytes.HasPrefix(fields[0], []byte(configUpdateTag)) || len(fields[1]) != 8 {
+		return nil, ErrMalformedConfigTx
+	}
+	u := &ConfigUpdate{Channel: string(fields[0][len(configUpdateTag):]), Version: binary.BigEndian.Uint64(fields[1])}
+	for _, field := range fields[2:] {
+		parts, err := DecodeFields(field)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 7 || len(parts[0]) != 1 || len(parts[5]) != 4 || len(parts[6]) != 8 {
+			return nil, ErrMalformedConfigTx
+		}
+		change := ConfigChange{Op: ConfigOp(parts[0][0]), Org: string(parts[1]), Quorum: int(binary.BigEndian.Uint32(parts[5])),
+			Height: binary.BigEndian.Uint64(parts[6])}
+		if len(parts[2]) > 0 {
+			if change.Key, err = ParseBLSBytes[*blschia.G1Element](parts[2]); err != nil {
+				return nil, err
+			}
+		}
+		if len(parts[3]) > 0 {
+			if change.PoP, err = ParseBLSBytes[*blschia.G2Element](parts[3]); err != nil {
+				return nil, err
+			}
+		}
+		orgs, err := DecodeFields(parts[4])
+		if err != nil {
+			return nil, err
+		}
+		for _, org := range orgs {
+			change.Orgs = append(change.Orgs, string(org))
+		}
+		u.Changes = append(u.Changes, change)
+	}
+	if !bytes.Equal(u.Bytes(), data) {
+		return nil, ErrMalformedConfigTx
+	}
+	return u, nil
+}
+
+// ConfigTransaction is a signed ConfigUpdate
+type ConfigTransaction struct {
+	Update    *ConfigUpdate
+	Signers   SignerBitmap // Admins that signed, by their index in ChannelConfig.Admins
+	Signature *blschia.G2Element
+}
+
+// Transaction is how the update travels and is ordered: the aggregate admin signature takes the place of the endorsement
+func (t *ConfigTransaction) Transaction() *Transaction {
+	return &Transaction{Proposal: EncodeProposal([]byte(configTransactionTag), t.Update.Bytes(), t.Signers), Endorsement: t.Signature}
+}
+
+// IsConfigTransaction reports whether proposal is that of a configuration transaction
+func IsConfigTransaction(proposal []byte) bool {
+	fields, err := DecodeFields(proposal)
+	return err == nil && len(fields) == 3 && string(fields[0]) == configTransactionTag
+}
+
+func DecodeConfigTransaction(tx *Transaction) (*ConfigTransaction, error) {
+	fields, err := DecodeFields(tx.Proposal)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 3 || string(fields[0]) != configTransactionTag {
+		return nil, ErrMalformedConfigTx
+	}
+	update, err := DecodeConfigUpdate(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigTransaction{Update: update, Signers: SignerBitmap(fields[2]), Signature: tx.Endorsement}, nil
+}
+
+// SignConfigUpdate aggregates the signatures of the admins in sks (admins missing from sks do not sign)
+func SignConfigUpdate(scheme SignatureScheme, config *ChannelConfig, update *ConfigUpdate, sks map[string]*blschia.PrivateKey) *ConfigTransaction {
+	t := &ConfigTransaction{Update: update, Signers: NewSignerBitmap(len(config.Admins))}
+	msg := update.Bytes()
+	var sigs []*blschia.G2Element
+	for i, admin := range config.Admins {
+		if sk, found := sks[admin]; found {
+			t.Signers.Set(i)
+			sigs = append(sigs, scheme.Sign(sk, msg))
+		}
+	}
+	t.Signature = scheme.AggregateSigs(sigs...)
+	return t
+}
+
+// AdminKeys resolves the signers of a configuration update to public keys, checking the admin quorum
+func (c *ChannelConfig) AdminKeys(signers SignerBitmap) ([]*blschia.G1Element, error) {
+	if len(signers) != len(NewSignerBitmap(len(c.Admins))) {
+		return nil, fmt.Errorf("%w: signer bitmap of %d bytes for %d admins", ErrConfigSignature, len(signers), len(c.Admins))
+	}
+	indices := signers.Indices()
+	if len(indices) > 0 && indices[len(indices)-1] >= len(c.Admins) {
+		return nil, fmt.Errorf("%w: unknown admin %d", ErrConfigSignature, indices[len(indices)-1])
+	}
+	quorum := c.AdminQuorum
+	if quorum == 0 {
+		quorum = len(c.Admins)
+	}
+	if len(indices) == 0 || len(indices) < quorum {
+		return nil, fmt.Errorf("%w: %d admins signed but the quorum is %d", ErrConfigSignature, len(indices), quorum)
+	}
+	pks := make([]*blschia.G1Element, len(indices))
+	for i, admin := range indices {
+		pk, found := c.Members[c.Admins[admin]]
+		if !found {
+			return nil, fmt.Errorf("config: admin %s is not a channel member", c.Admins[admin])
+		}
+		pks[i] = pk
+	}
+	return pks, nil
+}
+
+func (c *ChannelConfig) clone() *ChannelConfig {
+	next := *c
+	next.Members = make(map[string]*blschia.G1Element, len(c.Members))
+	for name, pk := range c.Members {
+		next.Members[name] = pk
+	}
+	next.Endorsers = slices.Clone(c.Endorsers)
+	next.Admins = slices.Clone(c.Admins)
+	next.Consenters = slices.Clone(c.Consenters)
+	next.Revoked = slices.Clone(c.Revoked)
+	return &next
+}
+
+// validate checks that the policies only name members
+func (c *ChannelConfig) validate() error {
+	if len(c.Endorsers) == 0 || len(c.Admins) == 0 {
+		return fmt.Errorf("%w: empty endorsement or admin policy", ErrInvalidConfig)
+	}
+	for _, name := range append(slices.Clone(c.Endorsers), c.Admins...) {
+		if _, found := c.Members[name]; !found {
+			return fmt.Errorf("%w: %s is in a policy but not a member", ErrInvalidConfig, name)
+		}
+	}
+	if c.AdminQuorum < 0 || c.AdminQuorum > len(c.Admins) {
+		return fmt.Errorf("%w: admin quorum %d of %d", ErrInvalidConfig, c.AdminQuorum, len(c.Admins))
+	}
+	return nil
+}
+
+// ApplyConfig verifies a configuration transaction against this configuration and returns the next version
+func (c *ChannelConfig) ApplyConfig(scheme SignatureScheme, t *ConfigTransaction) (*ChannelConfig, error) {
+	if c.ChannelID == "" {
+		return nil, ErrChannelID
+	}
+	if t.Update.Channel != c.ChannelID {
+		return nil, fmt.Errorf("%w: %q instead of %q", ErrWrongChannel, t.Update.Channel, c.ChannelID)
+	}
+	if t.Update.Version != c.Version {
+		return nil, fmt.Errorf("%w: update for %d, configuration is at %d", ErrConfigVersion, t.Update.Version, c.Version)
+	}
+	pks, err := c.AdminKeys(t.Signers)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySameMessage(scheme, pks, t.Update.Bytes(), t.Signature) {
+		return nil, ErrConfigSignature
+	}
+
+	pop := blschia.NewPopSchemeMPL()
+	next := c.clone()
+	for _, change := range t.Update.Changes {
+		_, member := next.Members[change.Org]
+		switch change.Op {
+		case ConfigAddOrg, ConfigRotateKey:
+			if change.Op == ConfigAddOrg && member {
+				return nil, fmt.Errorf("%w: %s is already a member", ErrInvalidConfig, change.Org)
+			}
+			if change.Op == ConfigRotateKey && !member {
+				return nil, fmt.Errorf("%w: %s is not a member", ErrInvalidConfig, change.Org)
+			}
+			if change.Org == "" || change.Key == nil || change.PoP == nil || !pop.PopVerify(change.Key, change.PoP) {
+				return nil, fmt.Errorf("%w for %s", ErrConfigPoP, change.Org)
+			}
+			next.Members[change.Org] = change.Key
+		case ConfigRemoveOrg:
+			if !member {
+				return nil, fmt.Errorf("%w: %s is not a member", ErrInvalidConfig, change.Org)
+			}
+			delete(next.Members, change.Org)
+		case ConfigSetEndorsers:
+			next.Endorsers = slices.Clone(change.Orgs)
+		case ConfigSetAdmins:
+			next.Admins = slices.Clone(change.Orgs)
+			next.AdminQuorum = change.Quorum
+		case ConfigRevokeKey:
+			if change.Key == nil {
+				return nil, fmt.Errorf("%w: revocation without a key", ErrInvalidConfig)
+			}
+			next.Revoked = append(next.Revoked, Revocation{Key: change.Key, Height: change.Height})
+		default:
+			return nil, fmt.Errorf("%w: unknown operation %d", ErrMalformedConfigTx, change.Op)
+		}
+	}
+	if err := next.validate(); err != nil {
+		return nil, err
+	}
+	next.Version++
+	return next, nil
+}
+
+// verifyConfigBlock checks a block holding a single configuration transaction
+func (c *ChannelConfig) verifyConfigBlock(scheme SignatureScheme, ordererPks []*blschia.G1Element, block *Block) bool {
+	return block.verify(scheme, ordererPks, func(tx *Transaction) ([]*blschia.G1Element, []byte, error) {
+		t, err := DecodeConfigTransaction(tx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := c.ApplyConfig(scheme, t); err != nil {
+			return nil, nil, err
+		}
+		pks, err := c.AdminKeys(t.Signers)
+		if err == nil {
+			err = c.CheckRevoked(pks, block.Header.Number)
+		}
+		return pks, t.Update.Bytes(), err
+	})
+}
+
+// IsConfigBlock reports whether block carries a configuration transaction (always on its own)
+func IsConfigBlock(block *Block) bool {
+	return len(block.Transactions) == 1 && IsConfigTransaction(block.Transactions[0].Proposal)
+}
+
+// exampleSync commits the blocks of an orderer to a peer until block number is committed
+func exampleSync(peer *ChannelPeer, channel string, orderer *Orderer, number uint64) {
+	for {
+		committed, _, _ := peer.Blocks(channel, 0)
+		blocks, update := orderer.Blocks(uint64(len(committed)))
+		for _, block := range blocks {
+			if err := peer.Commit(channel, block); err != nil {
+				panic(err)
+			}
+		}
+		if len(committed)+len(blocks) > int(number) {
+			return
+		}
+		<-update
+	}
+}
+
+// newOrgKey generates a key and its proof of possession
+func newOrgKey(scheme blschia.Scheme) (*blschia.PrivateKey, *blschia.G1Element, *blschia.G2Element) {
+	seed, _ := makeRandomArray(32)
+	sk, _ := scheme.KeyGen(seed)
+	pk, _ := sk.G1Element()
+	return sk, pk, blschia.NewPopSchemeMPL().PopProve(sk)
+}
+
+// Adding ICICI and rotating HDFC's key under a 3 of 4 admin policy, then removing RBI. Transactions endorsed with the
+// rotated key are rejected after the configuration block while the blocks before it still verify.
+func ConfigTransactionExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	config.ChannelID = "upi-p2p"
+	config.Admins, config.AdminQuorum = exampleOrganisations, 3
+	orderer := NewOrderer(scheme, config, orderer_sk, OrdererConfig{MaxTransactions: 10, BatchTimeout: 20 * time.Millisecond})
+	peer := NewChannelPeer(scheme)
+	if err := peer.Join(config); err != nil {
+		panic(err)
+	}
+	pay := func(sks map[string]*blschia.PrivateKey, config *ChannelConfig) *Transaction {
+		proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", 1500_00).Bytes(), time.Minute).Bytes()
+		return exampleEndorse(scheme, config, sks, proposal)
+	}
+
+	old_sks := sks
+	old := pay(old_sks, config)
+	if err := orderer.Submit(old); err != nil {
+		panic(err)
+	}
+
+	icici_sk, icici_pk, icici_pop := newOrgKey(scheme)
+	hdfc_sk, hdfc_pk, hdfc_pop := newOrgKey(scheme)
+	update := &ConfigUpdate{Channel: config.ChannelID, Version: 0, Changes: []ConfigChange{
+		{Op: ConfigAddOrg, Org: "ICICI", Key: icici_pk, PoP: icici_pop},
+		{Op: ConfigRotateKey, Org: "HDFC", Key: hdfc_pk, PoP: hdfc_pop},
+		{Op: ConfigSetEndorsers, Orgs: []string{"NPCI", "RBI", "SBI", "HDFC", "ICICI"}},
+	}}
+	if err := orderer.Submit(SignConfigUpdate(scheme, config, update, map[string]*blschia.PrivateKey{"NPCI": sks["NPCI"], "RBI": sks["RBI"]}).Transaction()); !errors.Is(err, ErrConfigSignature) {
+		panic(fmt.Sprintf("orderer accepted an update below the admin quorum: %v", err))
+	}
+	forged := *update
+	forged.Changes = []ConfigChange{{Op: ConfigRotateKey, Org: "HDFC", Key: hdfc_pk, PoP: icici_pop}}
+	if err := orderer.Submit(SignConfigUpdate(scheme, config, &forged, sks).Transaction()); !errors.Is(err, ErrConfigPoP) {
+		panic(fmt.Sprintf("orderer accepted a key without its proof of possession: %v", err))
+	}
+	signed := SignConfigUpdate(scheme, config, update, map[string]*blschia.PrivateKey{"NPCI": sks["NPCI"], "RBI": sks["RBI"], "SBI": sks["SBI"]})
+	if err := orderer.Submit(signed.Transaction()); err != nil {
+		panic(err)
+	}
+	if err := orderer.Submit(signed.Transaction()); !errors.Is(err, ErrConfigVersion) {
+		panic(fmt.Sprintf("orderer applied a configuration update twice: %v", err))
+	}
+
+	exampleSync(peer, config.ChannelID, orderer, 1)
+	v1, _ := peer.Config(config.ChannelID)
+	new_sks := map[string]*blschia.PrivateKey{"NPCI": sks["NPCI"], "RBI": sks["RBI"], "SBI": sks["SBI"], "HDFC": hdfc_sk, "ICICI": icici_sk}
+	stale_sks := map[string]*blschia.PrivateKey{"NPCI": sks["NPCI"], "RBI": sks["RBI"], "SBI": sks["SBI"], "HDFC": old_sks["HDFC"], "ICICI": icici_sk}
+	if err := orderer.Submit(pay(stale_sks, v1)); err == nil {
+		panic("orderer accepted an endorsement by a rotated key")
+	}
+	if err := orderer.Submit(pay(new_sks, v1)); err != nil {
+		panic(err)
+	}
+
+	update = &ConfigUpdate{Channel: config.ChannelID, Version: 1, Changes: []ConfigChange{
+		{Op: ConfigRemoveOrg, Org: "RBI"},
+		{Op: ConfigSetEndorsers, Orgs: []string{"NPCI", "SBI", "HDFC", "ICICI"}},
+		{Op: ConfigSetAdmins, Orgs: []string{"NPCI", "SBI", "HDFC", "ICICI"}, Quorum: 3},
+	}}
+	if err := orderer.Submit(SignConfigUpdate(scheme, v1, update, new_sks).Transaction()); err != nil {
+		panic(err)
+	}
+	delete(new_sks, "RBI")
+	exampleSync(peer, config.ChannelID, orderer, 3)
+	v2, _ := peer.Config(config.ChannelID)
+	if err := orderer.Submit(pay(new_sks, v2)); err != nil {
+		panic(err)
+	}
+	exampleSync(peer, config.ChannelID, orderer, 4)
+
+	blocks, _, _ := peer.Blocks(config.ChannelID, 0)
+	for _, block := range blocks {
+		at, _ := peer.ConfigAt(config.ChannelID, block.Header.Number)
+		if !at.VerifyBlock(scheme, block) {
+			panic(fmt.Sprintf("block %d does not verify under configuration version %d", block.Header.Number, at.Version))
+		}
+		fmt.Printf("configtx: block %d, %d transactions, configuration version %d, config block %v\n", block.Header.Number, len(block.Transactions), at.Version, IsConfigBlock(block))
+	}
+	if v2.VerifyBlock(scheme, blocks[0]) {
+		panic("a block ordered under version 0 verified under version 2")
+	}
+
+	// A checkpoint may end at a configuration block but not cross it
+	cp, err := BuildCheckpoint(scheme, config, blocks[:2])
+	if err != nil {
+		panic(err)
+	}
+	if err := cp.Verify(scheme, config); err != nil {
+		panic(err)
+	}
+	if _, err := BuildCheckpoint(scheme, config, blocks[:3]); !errors.Is(err, ErrCheckpointConfig) {
+		panic(fmt.Sprintf("built a checkpoint across a configuration block: %v", err))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Hash-to-curve cost grows with the message length, so signing the full 5000 byte proposal (or the whole block payload in the
+// orderer) is wasteful. Instead we sign a fixed size, domain-tagged digest of the canonical proposal encoding.
+
+// DigestAlgorithm selects the hash function used to compress a proposal before signing
+type DigestAlgorithm int
+
+const (
+	DigestSHA256 DigestAlgorithm = iota
+	DigestSHA512
+)
+
+// ProposalDigestTag is the default domain separation tag for proposal digests.
+// It keeps a digest from ever being confused with a raw payload or with a digest computed for some other purpose.
+const ProposalDigestTag = "CHIA-AGGSIG-PROPOSAL-DIGEST-V1"
+
+func (alg DigestAlgorithm) New() hash.Hash {
+	if alg == DigestSHA512 {
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+func (alg DigestAlgorithm) String() string {
+	if alg == DigestSHA512 {
+		return "SHA-512"
+	}
+	return "SHA-256"
+}
+
+func writeLengthPrefixed(w hash.Hash, field []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(field)))
+	w.Write(n[:])
+	w.Write(field)
+}
+
+// EncodeProposal returns the canonical encoding of the proposal fields.
+// Every field is written as an 8 byte big-endian length followed by its bytes so that distinct field lists never encode to the same bytes.
+func EncodeProposal(fields ...[]byte) []byte {
+	size := 0
+	for _, field := range fields {
+		size += 8 + len(field)
+	}
+	encoded := make([]byte, 0, size)
+	for _, field := range fields {
+		encoded = binary.BigEndian.AppendUint64(encoded, uint64(len(field)))
+		encoded = append(encoded, field...)
+	}
+	return encoded
+}
+
+// ProposalDigest hashes the domain separation tag and the canonical proposal encoding.
+// Equivalent to alg(EncodeProposal(tag, proposal)) without copying the proposal.
+func ProposalDigest(alg DigestAlgorithm, tag string, proposal []byte) []byte {
+	h := alg.New()
+	writeLengthPrefixed(h, []byte(tag))
+	writeLengthPrefixed(h, proposal)
+	return h.Sum(nil)
+}
+
+// DigestScheme wraps a signature scheme so that every message is replaced by its proposal digest before it reaches the scheme.
+// Signatures produced this way only verify through a DigestScheme with the same algorithm and tag.
+type DigestScheme struct {
+	Scheme    blschia.Scheme
+	Algorithm DigestAlgorithm
+	Tag       string
+}
+
+func NewDigestScheme(scheme blschia.Scheme, alg DigestAlgorithm) *DigestScheme {
+	return &DigestScheme{Scheme: scheme, Algorithm: alg, Tag: ProposalDigestTag}
+}
+
+func (d *DigestScheme) Digest(msg []byte) []byte {
+	return ProposalDigest(d.Algorithm, d.Tag, msg)
+}
+
+func (d *DigestScheme) Sign(sk *blschia.PrivateKey, msg []byte) *blschia.G2Element {
+	return d.Scheme.Sign(sk, d.Digest(msg))
+}
+
+func (d *DigestScheme) Verify(pk *blschia.G1Element, msg []byte, sig *blschia.G2Element) bool {
+	return d.Scheme.Verify(pk, d.Digest(msg), sig)
+}
+
+func (d *DigestScheme) AggregateSigs(sigs ...*blschia.G2Element) *blschia.G2Element {
+	return d.Scheme.AggregateSigs(sigs...)
+}
+
+// AggregateVerify digests each message once even if it repeats (as it does for the endorsements of a single proposal)
+func (d *DigestScheme) AggregateVerify(pks []*blschia.G1Element, msgs [][]byte, sig *blschia.G2Element) bool {
+	digests := make([][]byte, len(msgs))
+	cache := make(map[string][]byte)
+	for i, msg := range msgs {
+		digest, found := cache[string(msg)]
+		if !found {
+			digest = d.Digest(msg)
+			cache[string(msg)] = digest
+		}
+		digests[i] = digest
+	}
+	return d.Scheme.AggregateVerify(pks, digests, sig)
+}
+
+// FastAggregateVerify is only available when the wrapped scheme is the proof of possession scheme
+func (d *DigestScheme) FastAggregateVerify(pks []*blschia.G1Element, msg []byte, sig *blschia.G2Element) bool {
+	pop, ok := d.Scheme.(*blschia.PopSchemeMPL)
+	if !ok {
+		return false
+	}
+	return pop.FastAggregateVerify(pks, d.Digest(msg), sig)
+}
+
+// Same flow as OurProposalAugExample but every endorser and the orderer sign digests of the proposals and of the block payload
+func DigestProposalAugExample() {
+	npci_seed, _ := makeRandomArray(32)
+	rbi_seed, _ := makeRandomArray(32)
+	sbi_seed, _ := makeRandomArray(32)
+	hdfc_seed, _ := makeRandomArray(32)
+	orderer_seed, _ := makeRandomArray(32)
+
+	scheme := NewDigestScheme(blschia.NewAugSchemeMPL(), DigestSHA256)
+
+	npci_sk, _ := scheme.Scheme.KeyGen(npci_seed)
+	rbi_sk, _ := scheme.Scheme.KeyGen(rbi_seed)
+	sbi_sk, _ := scheme.Scheme.KeyGen(sbi_seed)
+	hdfc_sk, _ := scheme.Scheme.KeyGen(hdfc_seed)
+	orderer_sk, _ := scheme.Scheme.KeyGen(orderer_seed)
+
+	npci_pk, _ := npci_sk.G1Element()
+	rbi_pk, _ := rbi_sk.G1Element()
+	sbi_pk, _ := sbi_sk.G1Element()
+	hdfc_pk, _ := hdfc_sk.G1Element()
+	orderer_pk, _ := orderer_sk.G1Element()
+
+	proposal1, _ := makeRandomArray(5000) // Say for SBI to HDFC transfer
+	proposal2, _ := makeRandomArray(5000) // Say for HDFC to SBI transfer
+
+	// Each endorser hashes the proposal once and signs the 32 byte digest
+	transaction1_agg_sign := scheme.AggregateSigs(scheme.Sign(npci_sk, proposal1), scheme.Sign(rbi_sk, proposal1), scheme.Sign(sbi_sk, proposal1), scheme.Sign(hdfc_sk, proposal1))
+	ok := scheme.AggregateVerify([]*blschia.G1Element{npci_pk, rbi_pk, sbi_pk, hdfc_pk}, [][]byte{proposal1, proposal1, proposal1, proposal1}, transaction1_agg_sign)
+	if !ok {
+		panic("SBI client has sent an invalid transaction")
+	}
+
+	transaction2_agg_sign := scheme.AggregateSigs(scheme.Sign(npci_sk, proposal2), scheme.Sign(rbi_sk, proposal2), scheme.Sign(sbi_sk, proposal2), scheme.Sign(hdfc_sk, proposal2))
+	ok = scheme.AggregateVerify([]*blschia.G1Element{npci_pk, rbi_pk, sbi_pk, hdfc_pk}, [][]byte{proposal2, proposal2, proposal2, proposal2}, transaction2_agg_sign)
+	if !ok {
+		panic("HDFC client has sent an invalid transaction")
+	}
+
+	// The orderer signs the digest of the canonical block encoding instead of the concatenated payloads
+	block_payload := EncodeProposal(proposal1, proposal2)
+	block_orderer_sign := scheme.Sign(orderer_sk, block_payload)
+	block_sign := scheme.AggregateSigs(transaction1_agg_sign, transaction2_agg_sign, block_orderer_sign)
+
+	ok = scheme.AggregateVerify([]*blschia.G1Element{orderer_pk, npci_pk, rbi_pk, sbi_pk, hdfc_pk, npci_pk, rbi_pk, sbi_pk, hdfc_pk}, [][]byte{block_payload, proposal1, proposal1, proposal1, proposal1, proposal2, proposal2, proposal2, proposal2}, block_sign)
+	if !ok {
+		panic("failed a verification of the digest block signature")
+	}
+
+	// A raw payload signature must not verify as a digest signature (and vice versa)
+	if scheme.Verify(npci_pk, proposal1, scheme.Scheme.Sign(npci_sk, proposal1)) {
+		panic("raw payload signature verified as a digest signature")
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arun5309/chia/fabricpb"
+	"github.com/dashpay/bls-signatures/go-bindings"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Fabric message formats (see fabricpb/fabric.proto). A proposal maps onto a Fabric ENDORSER_TRANSACTION: the channel and
+// nonce of its envelope go into the channel and signature headers, its expiry into the header timestamp, and the proposal
+// bytes themselves into the chaincode input and the extension of the proposal response payload. Endorsers sign the
+// proposal bytes rather than the response payload, so every endorsement is over the same message and a transaction
+// carries a single Endorsement holding the aggregate instead of one ECDSA signature and certificate per endorser. The
+// endorsers are implied by the endorsement policy unless the transaction lists them, in which case there is an
+// Endorsement per endorser naming its organisation (a SerializedIdentity without a key, the channel configuration gives
+// the key) and the first one holds the aggregate. Clients are not authenticated by a signature of their own,
+// so SignedProposal and Envelope signatures are empty.
+//
+// A block keeps its header fields (the data hash is the transactions root), its transactions as Envelopes and both
+// orderer signatures in the SIGNATURES metadata: the aggregate as the value and the header signature as the only
+// metadata signature, whose signature header is the consenter bitmap.
+//
+// Encodings are deterministic and decoders reject anything that does not re-encode to the same bytes.
+
+var ErrFabricFormat = errors.New("fabric: malformed message")
+
+const (
+	fabricStatusOK        = 200
+	fabricMetadataSigs    = 0 // common.BlockMetadataIndex_SIGNATURES
+	fabricResponseVersion = 1
+)
+
+var fabricMarshalOptions = proto.MarshalOptions{Deterministic: true}
+
+// FabricIdentity is an msp.SerializedIdentity for the BLS key of an organisation
+func FabricIdentity(mspid string, pk *blschia.G1Element) ([]byte, error) {
+	return fabricMarshalOptions.Marshal(&fabricpb.SerializedIdentity{Mspid: mspid, IdBytes: pk.Serialize()})
+}
+
+// fabricProposal is the Fabric header and chaincode payload of a proposal, marshalled
+type fabricProposal struct {
+	header  []byte
+	payload []byte
+}
+
+func newFabricProposal(proposal, creator []byte) (*fabricProposal, error) {
+	e, err := DecodeProposalEnvelope(proposal)
+	if err != nil {
+		return nil, err
+	}
+	// Transaction ID as computed by Fabric
+	txID := sha256.Sum256(append(e.Nonce[:len(e.Nonce):len(e.Nonce)], creator...))
+	channelHeader, err := fabricMarshalOptions.Marshal(&fabricpb.ChannelHeader{
+		Type:      int32(fabricpb.HeaderType_ENDORSER_TRANSACTION),
+		Timestamp: timestamppb.New(e.Expiry),
+		ChannelId: e.Channel,
+		TxId:      hex.EncodeToString(txID[:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signatureHeader, err := fabricMarshalOptions.Marshal(&fabricpb.SignatureHeader{Creator: creator, Nonce: e.Nonce[:]})
+	if err != nil {
+		return nil, err
+	}
+	header, err := fabricMarshalOptions.Marshal(&fabricpb.Header{ChannelHeader: channelHeader, SignatureHeader: signatureHeader})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := fabricMarshalOptions.Marshal(&fabricpb.ChaincodeProposalPayload{Input: proposal})
+	if err != nil {
+		return nil, err
+	}
+	return &fabricProposal{header: header, payload: payload}, nil
+}
+
+// responsePayload is the ProposalResponsePayload, committing to the proposal as Fabric does
+func (p *fabricProposal) responsePayload(proposal []byte) ([]byte, error) {
+	hash := sha256.Sum256(append(p.header[:len(p.header):len(p.header)], p.payload...))
+	return fabricMarshalOptions.Marshal(&fabricpb.ProposalResponsePayload{ProposalHash: hash[:], Extension: proposal})
+}
+
+// fabricReencoded checks that data is the canonical encoding produced by encode
+func fabricReencoded(data []byte, encode func() ([]byte, error)) error {
+	canonical, err := encode()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(canonical, data) {
+		return ErrFabricFormat
+	}
+	return nil
+}
+
+// FabricSignedProposal is the peer.SignedProposal a client sends to endorsers. creator is the client's
+// SerializedIdentity.
+func FabricSignedProposal(proposal, creator []byte) ([]byte, error) {
+	p, err := newFabricProposal(proposal, creator)
+	if err != nil {
+		return nil, err
+	}
+	proposalBytes, err := fabricMarshalOptions.Marshal(&fabricpb.Proposal{Header: p.header, Payload: p.payload})
+	if err != nil {
+		return nil, err
+	}
+	return fabricMarshalOptions.Marshal(&fabricpb.SignedProposal{ProposalBytes: proposalBytes})
+}
+
+// DecodeFabricSignedProposal returns the proposal and creator of a SignedProposal
+func DecodeFabricSignedProposal(data []byte) (proposal, creator []byte, err error) {
+	var signed fabricpb.SignedProposal
+	var p fabricpb.Proposal
+	var header fabricpb.Header
+	var signatureHeader fabricpb.SignatureHeader
+	var payload fabricpb.ChaincodeProposalPayload
+	if proto.Unmarshal(data, &signed) != nil || proto.Unmarshal(signed.ProposalBytes, &p) != nil ||
+		proto.Unmarshal(p.Header, &header) != nil || proto.Unmarshal(header.SignatureHeader, &signatureHeader) != nil ||
+		proto.Unmarshal(p.Payload, &payload) != nil {
+		return nil, nil, ErrFabricFormat
+	}
+	proposal, creator = payload.Input, signatureHeader.Creator
+	if err := fabricReencoded(data, func() ([]byte, error) { return FabricSignedProposal(proposal, creator) }); err != nil {
+		return nil, nil, err
+	}
+	return proposal, creator, nil
+}
+
+// FabricProposalResponse is the peer.ProposalResponse of an endorser (identified by its SerializedIdentity) to the
+// proposal of creator
+func FabricProposalResponse(proposal, creator, endorser []byte, endorsement *blschia.G2Element) ([]byte, error) {
+	p, err := newFabricProposal(proposal, creator)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := p.responsePayload(proposal)
+	if err != nil {
+		return nil, err
+	}
+	return fabricMarshalOptions.Marshal(&fabricpb.ProposalResponse{
+		Version:     fabricResponseVersion,
+		Response:    &fabricpb.Response{Status: fabricStatusOK},
+		Payload:     payload,
+		Endorsement: &fabricpb.Endorsement{Endorser: endorser, Signature: endorsement.Serialize()},
+	})
+}
+
+// DecodeFabricProposalResponse returns the endorser and endorsement of a ProposalResponse to the proposal of creator
+func DecodeFabricProposalResponse(data, proposal, creator []byte) (endorser []byte, endorsement *blschia.G2Element, err error) {
+	var response fabricpb.ProposalResponse
+	if err := proto.Unmarshal(data, &response); err != nil || response.Endorsement == nil {
+		return nil, nil, ErrFabricFormat
+	}
+	if endorsement, err = ParseBLSBytes[*blschia.G2Element](response.Endorsement.Signature); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFabricFormat, err)
+	}
+	endorser = response.Endorsement.Endorser
+	if err := fabricReencoded(data, func() ([]byte, error) { return FabricProposalResponse(proposal, creator, endorser, endorsement) }); err != nil {
+		return nil, nil, err
+	}
+	return endorser, endorsement, nil
+}
+
+// FabricEnvelope is the common.Envelope of an endorsed transaction
+func FabricEnvelope(tx *Transaction, creator []byte) ([]byte, error) {
+	p, err := newFabricProposal(tx.Proposal, creator)
+	if err != nil {
+		return nil, err
+	}
+	responsePayload, err := p.responsePayload(tx.Proposal)
+	if err != nil {
+		return nil, err
+	}
+	endorsements := []*fabricpb.Endorsement{{}}
+	if len(tx.Endorsers) > 0 {
+		endorsements = make([]*fabricpb.Endorsement, len(tx.Endorsers))
+		for i, org := range tx.Endorsers {
+			endorser, err := fabricMarshalOptions.Marshal(&fabricpb.SerializedIdentity{Mspid: org})
+			if err != nil {
+				return nil, err
+			}
+			endorsements[i] = &fabricpb.Endorsement{Endorser: endorser}
+		}
+	}
+	endorsements[0].Signature = tx.Endorsement.Serialize()
+	return fabricEnvelope(p, responsePayload, endorsements)
+}
+
+func fabricEnvelope(p *fabricProposal, responsePayload []byte, endorsements []*fabricpb.Endorsement) ([]byte, error) {
+	var header fabricpb.Header
+	if err := proto.Unmarshal(p.header, &header); err != nil {
+		return nil, err
+	}
+	actionPayload, err := fabricMarshalOptions.Marshal(&fabricpb.ChaincodeActionPayload{
+		ChaincodeProposalPayload: p.payload,
+		Action:                   &fabricpb.ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload, Endorsements: endorsements},
+	})
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := fabricMarshalOptions.Marshal(&fabricpb.Transaction{Actions: []*fabricpb.TransactionAction{{Header: header.SignatureHeader, Payload: actionPayload}}})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := fabricMarshalOptions.Marshal(&fabricpb.Payload{Header: &header, Data: transaction})
+	if err != nil {
+		return nil, err
+	}
+	return fabricMarshalOptions.Marshal(&fabricpb.Envelope{Payload: payload})
+}
+
+// DecodeFabricEnvelope returns the transaction and creator of an Envelope
+func DecodeFabricEnvelope(data []byte) (*Transaction, []byte, error) {
+	var envelope fabricpb.Envelope
+	var payload fabricpb.Payload
+	var transaction fabricpb.Transaction
+	if proto.Unmarshal(data, &envelope) != nil || proto.Unmarshal(envelope.Payload, &payload) != nil ||
+		proto.Unmarshal(payload.Data, &transaction) != nil || len(transaction.Actions) != 1 {
+		return nil, nil, ErrFabricFormat
+	}
+	var signatureHeader fabricpb.SignatureHeader
+	var actionPayload fabricpb.ChaincodeActionPayload
+	var responsePayload fabricpb.ProposalResponsePayload
+	action := transaction.Actions[0]
+	if proto.Unmarshal(action.Header, &signatureHeader) != nil || proto.Unmarshal(action.Payload, &actionPayload) != nil ||
+		actionPayload.Action == nil || len(actionPayload.Action.Endorsements) == 0 ||
+		proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, &responsePayload) != nil {
+		return nil, nil, ErrFabricFormat
+	}
+	endorsement, err := ParseBLSBytes[*blschia.G2Element](actionPayload.Action.Endorsements[0].Signature)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFabricFormat, err)
+	}
+	tx := &Transaction{Proposal: responsePayload.Extension, Endorsement: endorsement}
+	if endorsements := actionPayload.Action.Endorsements; len(endorsements) > 1 || len(endorsements[0].Endorser) > 0 {
+		seen := make(map[string]bool)
+		for _, e := range endorsements {
+			var identity fabricpb.SerializedIdentity
+			if proto.Unmarshal(e.Endorser, &identity) != nil || identity.Mspid == "" || seen[identity.Mspid] {
+				return nil, nil, ErrFabricFormat
+			}
+			seen[identity.Mspid] = true
+			tx.Endorsers = append(tx.Endorsers, identity.Mspid)
+		}
+	}
+	creator := signatureHeader.Creator
+	if err := fabricReencoded(data, func() ([]byte, error) { return FabricEnvelope(tx, creator) }); err != nil {
+		return nil, nil, err
+	}
+	return tx, creator, nil
+}
+
+// FabricBlock is the common.Block of block. Transactions are encoded without a creator.
+func FabricBlock(block *Block) ([]byte, error) {
+	envelopes := make([][]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		var err error
+		if envelopes[i], err = FabricEnvelope(tx, nil); err != nil {
+			return nil, err
+		}
+	}
+	signatures, err := fabricMarshalOptions.Marshal(&fabricpb.Metadata{
+		Value:      block.AggregateSignature.Serialize(),
+		Signatures: []*fabricpb.MetadataSignature{{SignatureHeader: block.Signers, Signature: block.OrdererSignature.Serialize()}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fabricMarshalOptions.Marshal(&fabricpb.Block{
+		Header:   &fabricpb.BlockHeader{Number: block.Header.Number, PreviousHash: block.Header.PreviousHash, DataHash: block.Header.TransactionsRoot},
+		Data:     &fabricpb.BlockData{Data: envelopes},
+		Metadata: &fabricpb.BlockMetadata{Metadata: [][]byte{fabricMetadataSigs: signatures}},
+	})
+}
+
+// DecodeFabricBlock parses a Block. The signatures are not verified.
+func DecodeFabricBlock(data []byte) (*Block, error) {
+	var pb fabricpb.Block
+	var metadata fabricpb.Metadata
+	if proto.Unmarshal(data, &pb) != nil || pb.Header == nil || pb.Data == nil || pb.Metadata == nil ||
+		len(pb.Metadata.Metadata) != 1 || proto.Unmarshal(pb.Metadata.Metadata[fabricMetadataSigs], &metadata) != nil ||
+		len(metadata.Signatures) != 1 {
+		return nil, ErrFabricFormat
+	}
+	block := &Block{
+		Header: BlockHeader{
+			Number:           pb.Header.Number,
+			PreviousHash:     pb.Header.PreviousHash,
+			TransactionsRoot: pb.Header.DataHash,
+			TransactionCount: uint32(len(pb.Data.Data)),
+		},
+		Signers: metadata.Signatures[0].SignatureHeader,
+	}
+	var err error
+	if block.AggregateSignature, err = ParseBLSBytes[*blschia.G2Element](metadata.Value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFabricFormat, err)
+	}
+	if block.OrdererSignature, err = ParseBLSBytes[*blschia.G2Element](metadata.Signatures[0].Signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFabricFormat, err)
+	}
+	for _, envelope := range pb.Data.Data {
+		tx, creator, err := DecodeFabricEnvelope(envelope)
+		if err != nil {
+			return nil, err
+		}
+		if len(creator) > 0 {
+			return nil, ErrFabricFormat
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+	if err := fabricReencoded(data, func() ([]byte, error) { return FabricBlock(block) }); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// fabricECDSAEnvelope is the Envelope Fabric itself would carry for proposal: one endorsement per endorser, each
+// with the endorser's certificate and an ECDSA signature over the response payload and the endorser
+func fabricECDSAEnvelope(proposal, creator []byte, endorsers [][]byte, keys []*ecdsa.PrivateKey) ([]byte, error) {
+	p, err := newFabricProposal(proposal, creator)
+	if err != nil {
+		return nil, err
+	}
+	responsePayload, err := p.responsePayload(proposal)
+	if err != nil {
+		return nil, err
+	}
+	var endorsements []*fabricpb.Endorsement
+	for i, endorser := range endorsers {
+		digest := sha256.Sum256(append(responsePayload[:len(responsePayload):len(responsePayload)], endorser...))
+		signature, err := ecdsa.SignASN1(rand.Reader, keys[i], digest[:])
+		if err != nil {
+			return nil, err
+		}
+		endorsements = append(endorsements, &fabricpb.Endorsement{Endorser: endorser, Signature: signature})
+	}
+	return fabricEnvelope(p, responsePayload, endorsements)
+}
+
+// Transactions and a block in Fabric's formats, decoded back and verified, and the size of an Envelope with an
+// aggregate endorsement against one with Fabric's ECDSA endorsements and certificates
+func FabricFormatExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	config.ChannelID = "upi-p2p"
+	client_pk, _ := sks["SBI"].G1Element()
+	creator, _ := FabricIdentity("SBI", client_pk)
+
+	proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", 1500_00).Bytes(), time.Minute).Bytes()
+	signed, err := FabricSignedProposal(proposal, creator)
+	if err != nil {
+		panic(err)
+	}
+	received, received_creator, err := DecodeFabricSignedProposal(signed)
+	if err != nil || !bytes.Equal(received, proposal) || !bytes.Equal(received_creator, creator) {
+		panic(fmt.Sprintf("signed proposal did not round trip: %v", err))
+	}
+	var sigs []*blschia.G2Element
+	for _, org := range config.Endorsers {
+		pk, _ := sks[org].G1Element()
+		endorser, _ := FabricIdentity(org, pk)
+		response, err := FabricProposalResponse(proposal, creator, endorser, scheme.Sign(sks[org], proposal))
+		if err != nil {
+			panic(err)
+		}
+		_, sig, err := DecodeFabricProposalResponse(response, proposal, creator)
+		if err != nil {
+			panic(err)
+		}
+		sigs = append(sigs, sig)
+	}
+	tx := &Transaction{Proposal: proposal, Endorsement: scheme.AggregateSigs(sigs...)}
+	envelope, err := FabricEnvelope(tx, creator)
+	if err != nil {
+		panic(err)
+	}
+	decoded, _, err := DecodeFabricEnvelope(envelope)
+	if err != nil || !config.VerifyEndorsement(scheme, decoded) {
+		panic(fmt.Sprintf("envelope did not round trip: %v", err))
+	}
+	if _, _, err := DecodeFabricEnvelope(append(envelope, 0x12, 0)); !errors.Is(err, ErrFabricFormat) {
+		panic("decoded an envelope with a trailing field")
+	}
+
+	txs := []*Transaction{tx}
+	for i := 1; i < 10; i++ {
+		proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", uint64(100_00*i)).Bytes(), time.Minute).Bytes()
+		txs = append(txs, exampleEndorse(scheme, config, sks, proposal))
+	}
+	block := NewBlock(nil, txs)
+	block.Sign(scheme, orderer_sk)
+	encoded, err := FabricBlock(block)
+	if err != nil {
+		panic(err)
+	}
+	decoded_block, err := DecodeFabricBlock(encoded)
+	if err != nil || !config.VerifyBlock(scheme, decoded_block) {
+		panic(fmt.Sprintf("block did not round trip: %v", err))
+	}
+	fmt.Printf("fabric: block of %d transactions is %d bytes\n", len(txs), len(encoded))
+
+	// Fabric's own endorsements: an X.509 certificate and an ECDSA signature per endorser
+	ca, _ := NewLocalCA("UPI Network CA", time.Hour)
+	for _, n := range []int{1, 4, 8, 16} {
+		endorsers := make([][]byte, n)
+		keys := make([]*ecdsa.PrivateKey, n)
+		var sigs []*blschia.G2Element
+		for i := 0; i < n; i++ {
+			org := fmt.Sprintf("Org%dMSP", i)
+			sk, pk, pop := newOrgKey(scheme)
+			keys[i], _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			cert, err := ca.Issue(org, pk, pop, keys[i].Public(), time.Hour)
+			if err != nil {
+				panic(err)
+			}
+			endorsers[i], _ = fabricMarshalOptions.Marshal(&fabricpb.SerializedIdentity{Mspid: org, IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})})
+			sigs = append(sigs, scheme.Sign(sk, proposal))
+		}
+		ecdsa_envelope, err := fabricECDSAEnvelope(proposal, creator, endorsers, keys)
+		if err != nil {
+			panic(err)
+		}
+		bls_envelope, _ := FabricEnvelope(&Transaction{Proposal: proposal, Endorsement: scheme.AggregateSigs(sigs...)}, creator)
+		fmt.Printf("fabric: %2d endorsers, envelope %5d bytes with ECDSA endorsements, %d bytes with a BLS aggregate\n", n, len(ecdsa_envelope), len(bls_envelope))
+	}
+}
@@ -0,0 +1,3 @@
+package fabricpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative fabric.proto
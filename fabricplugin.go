@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arun5309/chia/fabricpb"
+	"github.com/dashpay/bls-signatures/go-bindings"
+	"google.golang.org/protobuf/proto"
+)
+
+// Fabric endorsement and validation plugins. The interfaces mirror Fabric's core/handlers/endorsement/api and
+// core/handlers/validation/api with the fabricpb messages in place of Fabric's, so the plugins drop into a peer by
+// swapping the mirrors for Fabric's packages. Their dependencies are what a peer passes to Init: the endorsement plugin
+// needs a BLSSigningIdentity (and optionally a ReplayGuard), the validation plugin a ChannelConfigFetcher such as
+// ChannelPeer. The endorsement policy of a chaincode is an EndorsementPolicy, handed to Validate as a SerializedPolicy.
+//
+// Endorsements are made and aggregated as in OurProposalPopExample: every endorser signs the proposal itself, the
+// client aggregates the endorsements into the Envelope along with the organisations of the endorsers (see fabric.go)
+// and the validator checks the aggregate against their keys in one pairing check, then that they include every
+// organisation of the policy. The keys are those of the configuration the block is validated under.
+
+// PluginDependency is a dependency passed to a plugin's Init
+type PluginDependency interface{}
+
+// ContextDatum is extra information passed to Validate
+type ContextDatum interface{}
+
+// EndorsementPlugin endorses the response payload of a proposal and returns the endorsement and the (possibly
+// modified) payload
+type EndorsementPlugin interface {
+	Endorse(payload []byte, sp *fabricpb.SignedProposal) (*fabricpb.Endorsement, []byte, error)
+	Init(dependencies ...PluginDependency) error
+}
+
+type EndorsementPluginFactory interface {
+	New() EndorsementPlugin
+}
+
+// ValidationPlugin validates an action of a transaction of a block. A nil error means the transaction is valid, an
+// *ExecutionFailureError that it could not be validated and any other error that it is invalid.
+type ValidationPlugin interface {
+	Validate(block *fabricpb.Block, namespace string, txPosition int, actionPosition int, contextData ...ContextDatum) error
+	Init(dependencies ...PluginDependency) error
+}
+
+type ValidationPluginFactory interface {
+	New() ValidationPlugin
+}
+
+// SerializedPolicy is the endorsement policy of the namespace being validated
+type SerializedPolicy interface {
+	Bytes() []byte
+}
+
+// ExecutionFailureError reports that validation could not be carried out, as opposed to the transaction being invalid
+type ExecutionFailureError struct {
+	Reason string
+}
+
+func (e *ExecutionFailureError) Error() string {
+	return e.Reason
+}
+
+// BLSSigningIdentity is the endorsing peer's organisation and BLS key
+type BLSSigningIdentity interface {
+	Serialize() ([]byte, error) // SerializedIdentity
+	Sign(msg []byte) *blschia.G2Element
+}
+
+// ChannelConfigFetcher gives the configuration a block of a channel is validated against
+type ChannelConfigFetcher interface {
+	ConfigAt(channel string, number uint64) (*ChannelConfig, error)
+}
+
+var (
+	ErrPluginDependency = errors.New("plugin: missing dependency")
+	ErrPluginProposal   = errors.New("plugin: payload is not the response payload of the proposal")
+	ErrPolicyFormat     = errors.New("plugin: malformed endorsement policy")
+	ErrPolicyNotMet     = errors.New("plugin: aggregate endorsement does not satisfy the endorsement policy")
+)
+
+const endorsementPolicyTag = "bls-endorsement-policy"
+
+// EndorsementPolicy lists the organisations that must all endorse a chaincode's transactions
+type EndorsementPolicy []string
+
+func (p EndorsementPolicy) Bytes() []byte {
+	fields := [][]byte{[]byte(endorsementPolicyTag)}
+	for _, org := range p {
+		fields = append(fields, []byte(org))
+	}
+	return EncodeProposal(fields...)
+}
+
+func DecodeEndorsementPolicy(data []byte) (EndorsementPolicy, error) {
+	fields, err := DecodeFields(data)
+	if err != nil || len(fields) < 2 || string(fields[0]) != endorsementPolicyTag {
+		return nil, ErrPolicyFormat
+	}
+	policy := make(EndorsementPolicy, 0, len(fields)-1)
+	seen := make(map[string]bool)
+	for _, field := range fields[1:] {
+		if len(field) == 0 || seen[string(field)] {
+			return nil, ErrPolicyFormat
+		}
+		seen[string(field)] = true
+		policy = append(policy, string(field))
+	}
+	if !bytes.Equal(policy.Bytes(), data) {
+		return nil, ErrPolicyFormat
+	}
+	return policy, nil
+}
+
+type blsSigningIdentity struct {
+	mspid  string
+	scheme SignatureScheme
+	sk     *blschia.PrivateKey
+}
+
+func NewBLSSigningIdentity(mspid string, scheme SignatureScheme, sk *blschia.PrivateKey) BLSSigningIdentity {
+	return &blsSigningIdentity{mspid: mspid, scheme: scheme, sk: sk}
+}
+
+func (i *blsSigningIdentity) Serialize() ([]byte, error) {
+	pk, err := i.sk.G1Element()
+	if err != nil {
+		return nil, err
+	}
+	return FabricIdentity(i.mspid, pk)
+}
+
+func (i *blsSigningIdentity) Sign(msg []byte) *blschia.G2Element {
+	return i.scheme.Sign(i.sk, msg)
+}
+
+// BLSEndorsementPluginFactory makes plugins that endorse with the BLSSigningIdentity they are initialised with
+type BLSEndorsementPluginFactory struct{}
+
+func (BLSEndorsementPluginFactory) New() EndorsementPlugin {
+	return &blsEndorsementPlugin{}
+}
+
+type blsEndorsementPlugin struct {
+	identity BLSSigningIdentity
+	guard    *ReplayGuard
+}
+
+func (p *blsEndorsementPlugin) Init(dependencies ...PluginDependency) error {
+	for _, dependency := range dependencies {
+		switch dependency := dependency.(type) {
+		case BLSSigningIdentity:
+			p.identity = dependency
+		case *ReplayGuard:
+			p.guard = dependency
+		}
+	}
+	if p.identity == nil {
+		return fmt.Errorf("%w: BLSSigningIdentity", ErrPluginDependency)
+	}
+	return nil
+}
+
+// Endorse signs the proposal of sp after checking payload is its response payload
+func (p *blsEndorsementPlugin) Endorse(payload []byte, sp *fabricpb.SignedProposal) (*fabricpb.Endorsement, []byte, error) {
+	signed, err := fabricMarshalOptions.Marshal(sp)
+	if err != nil {
+		return nil, nil, err
+	}
+	proposal, creator, err := DecodeFabricSignedProposal(signed)
+	if err != nil {
+		return nil, nil, err
+	}
+	fp, err := newFabricProposal(proposal, creator)
+	if err != nil {
+		return nil, nil, err
+	}
+	expected, err := fp.responsePayload(proposal)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(payload, expected) {
+		return nil, nil, ErrPluginProposal
+	}
+	if p.guard != nil {
+		if _, err := p.guard.Check(proposal, time.Now()); err != nil {
+			return nil, nil, err
+		}
+	}
+	endorser, err := p.identity.Serialize()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &fabricpb.Endorsement{Endorser: endorser, Signature: p.identity.Sign(proposal).Serialize()}, payload, nil
+}
+
+// BLSValidationPluginFactory makes plugins that validate aggregate endorsements under Scheme
+type BLSValidationPluginFactory struct {
+	Scheme SignatureScheme
+}
+
+func (f BLSValidationPluginFactory) New() ValidationPlugin {
+	return &blsValidationPlugin{scheme: f.Scheme}
+}
+
+type blsValidationPlugin struct {
+	scheme  SignatureScheme
+	configs ChannelConfigFetcher
+}
+
+func (p *blsValidationPlugin) Init(dependencies ...PluginDependency) error {
+	for _, dependency := range dependencies {
+		if configs, ok := dependency.(ChannelConfigFetcher); ok {
+			p.configs = configs
+		}
+	}
+	if p.configs == nil {
+		return fmt.Errorf("%w: ChannelConfigFetcher", ErrPluginDependency)
+	}
+	return nil
+}
+
+// Validate checks the aggregate endorsement of transaction txPosition against the keys the channel configuration at
+// the block gives its endorsers, and that they include the organisations of the policy in contextData. A transaction
+// that does not list its endorsers is taken to be endorsed by exactly the organisations of the policy.
+func (p *blsValidationPlugin) Validate(block *fabricpb.Block, namespace string, txPosition int, actionPosition int, contextData ...ContextDatum) error {
+	var policy SerializedPolicy
+	for _, datum := range contextData {
+		if datum, ok := datum.(SerializedPolicy); ok {
+			policy = datum
+		}
+	}
+	if policy == nil {
+		return &ExecutionFailureError{Reason: fmt.Sprintf("no endorsement policy for namespace %s", namespace)}
+	}
+	if block.GetHeader() == nil || txPosition < 0 || txPosition >= len(block.GetData().GetData()) {
+		return &ExecutionFailureError{Reason: fmt.Sprintf("block has no transaction %d", txPosition)}
+	}
+	if actionPosition != 0 {
+		return fmt.Errorf("%w: action %d", ErrFabricFormat, actionPosition)
+	}
+	orgs, err := DecodeEndorsementPolicy(policy.Bytes())
+	if err != nil {
+		return &ExecutionFailureError{Reason: err.Error()}
+	}
+	tx, _, err := DecodeFabricEnvelope(block.Data.Data[txPosition])
+	if err != nil {
+		return err
+	}
+	channel, err := ProposalChannel(tx.Proposal)
+	if err != nil {
+		return err
+	}
+	config, err := p.configs.ConfigAt(channel, block.Header.Number)
+	if err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		if _, found := config.Members[org]; !found {
+			return &ExecutionFailureError{Reason: fmt.Sprintf("policy organisation %s is not a member of %s", org, channel)}
+		}
+	}
+	endorsers := tx.Endorsers
+	if len(endorsers) == 0 {
+		endorsers = orgs
+	}
+	endorsed := make(map[string]bool)
+	pks := make([]*blschia.G1Element, len(endorsers))
+	for i, org := range endorsers {
+		pk, found := config.Members[org]
+		if !found {
+			return fmt.Errorf("%w: endorser %s is not a member of %s", ErrPolicyNotMet, org, channel)
+		}
+		pks[i] = pk
+		endorsed[org] = true
+	}
+	if err := config.CheckRevoked(pks, block.Header.Number); err != nil {
+		return err
+	}
+	if !p.verify(pks, tx.Proposal, tx.Endorsement) {
+		return ErrPolicyNotMet
+	}
+	for _, org := range orgs {
+		if !endorsed[org] {
+			return fmt.Errorf("%w: %s did not endorse", ErrPolicyNotMet, org)
+		}
+	}
+	return nil
+}
+
+// verify uses FastAggregateVerify under the proof of possession scheme, as every endorser signed the same message
+func (p *blsValidationPlugin) verify(pks []*blschia.G1Element, msg []byte, sig *blschia.G2Element) bool {
+	if pop, ok := p.scheme.(*blschia.PopSchemeMPL); ok {
+		return pop.FastAggregateVerify(pks, msg, sig)
+	}
+	msgs := make([][]byte, len(pks))
+	for i := range msgs {
+		msgs[i] = msg
+	}
+	return p.scheme.AggregateVerify(pks, msgs, sig)
+}
+
+// fabricEndorse is the client side of endorsement on a Fabric network: the signed proposal goes to the endorsement
+// plugin of every endorser and the endorsements are aggregated into a transaction listing the endorsers
+func fabricEndorse(scheme SignatureScheme, plugins []EndorsementPlugin, proposal, creator []byte) (*Transaction, error) {
+	signed, err := FabricSignedProposal(proposal, creator)
+	if err != nil {
+		return nil, err
+	}
+	var sp fabricpb.SignedProposal
+	if err := proto.Unmarshal(signed, &sp); err != nil {
+		return nil, err
+	}
+	fp, err := newFabricProposal(proposal, creator)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := fp.responsePayload(proposal)
+	if err != nil {
+		return nil, err
+	}
+	sigs := make([]*blschia.G2Element, len(plugins))
+	endorsers := make([]string, len(plugins))
+	for i, plugin := range plugins {
+		endorsement, _, err := plugin.Endorse(payload, &sp)
+		if err != nil {
+			return nil, err
+		}
+		if sigs[i], err = ParseBLSBytes[*blschia.G2Element](endorsement.Signature); err != nil {
+			return nil, err
+		}
+		var identity fabricpb.SerializedIdentity
+		if err := proto.Unmarshal(endorsement.Endorser, &identity); err != nil {
+			return nil, err
+		}
+		endorsers[i] = identity.Mspid
+	}
+	return &Transaction{Proposal: proposal, Endorsement: scheme.AggregateSigs(sigs...), Endorsers: endorsers}, nil
+}
+
+// The plugins on stand-in peers: four endorsing peers, a client aggregating their endorsements and a committing peer
+// validating every transaction of a block under the chaincode's policy
+func FabricPluginExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	config.ChannelID = "upi-p2p"
+	peer := NewChannelPeer(scheme)
+	if err := peer.Join(config); err != nil {
+		panic(err)
+	}
+
+	var plugins []EndorsementPlugin
+	for _, org := range config.Endorsers {
+		plugin := BLSEndorsementPluginFactory{}.New()
+		guard := NewReplayGuard(config.ChannelID, DefaultReplayCapacity, DefaultProposalTTL)
+		if err := plugin.Init(NewBLSSigningIdentity(org, scheme, sks[org]), guard); err != nil {
+			panic(err)
+		}
+		plugins = append(plugins, plugin)
+	}
+	client_pk, _ := sks["SBI"].G1Element()
+	creator, _ := FabricIdentity("SBI", client_pk)
+	var txs []*Transaction
+	for i := 1; i <= 5; i++ {
+		proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", uint64(100_00*i)).Bytes(), time.Minute).Bytes()
+		tx, err := fabricEndorse(scheme, plugins, proposal, creator)
+		if err != nil {
+			panic(err)
+		}
+		txs = append(txs, tx)
+	}
+	if _, err := fabricEndorse(scheme, plugins, txs[0].Proposal, creator); !errors.Is(err, ErrReplay) {
+		panic("endorsement plugin endorsed a replayed proposal")
+	}
+	// Endorsed by NPCI and RBI only
+	proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", 600_00).Bytes(), time.Minute).Bytes()
+	partial, err := fabricEndorse(scheme, plugins[:2], proposal, creator)
+	if err != nil {
+		panic(err)
+	}
+
+	block := NewBlock(nil, append(txs, partial))
+	block.Sign(scheme, orderer_sk)
+	encoded, _ := FabricBlock(block)
+	var pb fabricpb.Block
+	if err := proto.Unmarshal(encoded, &pb); err != nil {
+		panic(err)
+	}
+	validator := BLSValidationPluginFactory{Scheme: scheme}.New()
+	if err := validator.Init(peer); err != nil {
+		panic(err)
+	}
+	for i := range txs {
+		if err := validator.Validate(&pb, "upi", i, 0, EndorsementPolicy(config.Endorsers)); err != nil {
+			panic(err)
+		}
+	}
+	// A policy satisfied by some of the endorsers is met, one they do not cover is not, and neither is the lack of a policy
+	partial_position := len(txs)
+	for _, i := range []int{0, partial_position} {
+		if err := validator.Validate(&pb, "upi", i, 0, EndorsementPolicy{"NPCI", "RBI"}); err != nil {
+			panic(err)
+		}
+	}
+	if err := validator.Validate(&pb, "upi", partial_position, 0, EndorsementPolicy(config.Endorsers)); !errors.Is(err, ErrPolicyNotMet) {
+		panic(fmt.Sprintf("validated a transaction under a policy it was not endorsed under: %v", err))
+	}
+	var failure *ExecutionFailureError
+	if err := validator.Validate(&pb, "upi", 0, 0); !errors.As(err, &failure) {
+		panic("validated a transaction without a policy")
+	}
+	fmt.Printf("fabric plugins: %d transactions endorsed by %d plugins and validated\n", len(txs), len(plugins))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arun5309/chia/fabricpb"
+	"github.com/dashpay/bls-signatures/go-bindings"
+	"google.golang.org/protobuf/proto"
+)
+
+// configStandIn stands in for the peer's channel configuration
+type configStandIn map[string]*ChannelConfig
+
+func (c configStandIn) ConfigAt(channel string, number uint64) (*ChannelConfig, error) {
+	config, found := c[channel]
+	if !found {
+		return nil, ErrUnknownChannel
+	}
+	return config, nil
+}
+
+// policyStandIn stands in for the peer's serialized policy of a namespace
+type policyStandIn []byte
+
+func (p policyStandIn) Bytes() []byte { return p }
+
+func TestFabricPlugins(t *testing.T) {
+	for name, scheme := range map[string]blschia.Scheme{"aug": blschia.NewAugSchemeMPL(), "pop": blschia.NewPopSchemeMPL()} {
+		t.Run(name, func(t *testing.T) {
+			config, sks, orderer_sk := exampleChannel(scheme)
+			config.ChannelID = "upi-p2p"
+			var plugins []EndorsementPlugin
+			for _, org := range config.Endorsers {
+				plugin := BLSEndorsementPluginFactory{}.New()
+				if err := plugin.Init(NewBLSSigningIdentity(org, scheme, sks[org])); err != nil {
+					t.Fatal(err)
+				}
+				plugins = append(plugins, plugin)
+			}
+			proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", 1500_00).Bytes(), time.Minute).Bytes()
+			tx, err := fabricEndorse(scheme, plugins, proposal, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proposal = NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", 420_50).Bytes(), time.Minute).Bytes()
+			partial, err := fabricEndorse(scheme, plugins[:3], proposal, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block := NewBlock(nil, []*Transaction{tx, partial})
+			block.Sign(scheme, orderer_sk)
+			encoded, _ := FabricBlock(block)
+			var pb fabricpb.Block
+			if err := proto.Unmarshal(encoded, &pb); err != nil {
+				t.Fatal(err)
+			}
+
+			validator := BLSValidationPluginFactory{Scheme: scheme}.New()
+			if err := validator.Init(); !errors.Is(err, ErrPluginDependency) {
+				t.Fatalf("initialised without a configuration fetcher: %v", err)
+			}
+			if err := validator.Init(configStandIn{config.ChannelID: config}); err != nil {
+				t.Fatal(err)
+			}
+			policy := policyStandIn(EndorsementPolicy(config.Endorsers).Bytes())
+			if err := validator.Validate(&pb, "upi", 0, 0, policy); err != nil {
+				t.Fatal(err)
+			}
+			var failure *ExecutionFailureError
+			for _, err := range []error{
+				validator.Validate(&pb, "upi", 0, 0),
+				validator.Validate(&pb, "upi", 2, 0, policy),
+				validator.Validate(&pb, "upi", 0, 0, policyStandIn("AND('Org1.member')")),
+				validator.Validate(&pb, "upi", 0, 0, EndorsementPolicy{"NPCI", "Axis"}),
+			} {
+				if !errors.As(err, &failure) {
+					t.Errorf("expected an execution failure, got %v", err)
+				}
+			}
+			// Endorsements by more organisations than the policy names meet it, by fewer do not
+			if err := validator.Validate(&pb, "upi", 0, 0, EndorsementPolicy{"NPCI", "RBI", "SBI"}); err != nil {
+				t.Errorf("did not validate under a policy the endorsers cover: %v", err)
+			}
+			if err := validator.Validate(&pb, "upi", 1, 0, EndorsementPolicy{"NPCI", "RBI", "SBI"}); err != nil {
+				t.Errorf("did not validate under the policy of exactly the endorsers: %v", err)
+			}
+			if err := validator.Validate(&pb, "upi", 1, 0, policy); !errors.Is(err, ErrPolicyNotMet) {
+				t.Errorf("validated under a policy the endorsers do not cover: %v", err)
+			}
+			// Claiming an endorser that did not sign fails the aggregate
+			claimed := *partial
+			claimed.Endorsers = config.Endorsers
+			forged := NewBlock(nil, []*Transaction{&claimed})
+			forged.Sign(scheme, orderer_sk)
+			encoded, _ = FabricBlock(forged)
+			var forged_pb fabricpb.Block
+			if err := proto.Unmarshal(encoded, &forged_pb); err != nil {
+				t.Fatal(err)
+			}
+			if err := validator.Validate(&forged_pb, "upi", 0, 0, policy); !errors.Is(err, ErrPolicyNotMet) {
+				t.Errorf("validated an endorsement claiming an endorser that did not sign: %v", err)
+			}
+
+			// Revoking an endorser invalidates its endorsements from the revocation height on
+			sbi_pk, _ := sks["SBI"].G1Element()
+			config.Revoked = []Revocation{{Key: sbi_pk, Height: 0}}
+			if err := validator.Validate(&pb, "upi", 0, 0, policy); !errors.Is(err, ErrKeyRevoked) {
+				t.Errorf("validated an endorsement by a revoked key: %v", err)
+			}
+
+			// The plugin only signs the response payload of the proposal it is shown
+			var sp fabricpb.SignedProposal
+			signed, _ := FabricSignedProposal(proposal, nil)
+			proto.Unmarshal(signed, &sp)
+			if _, _, err := plugins[0].Endorse([]byte("some other payload"), &sp); !errors.Is(err, ErrPluginProposal) {
+				t.Errorf("endorsed a payload of another proposal: %v", err)
+			}
+		})
+	}
+}
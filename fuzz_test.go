@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Fuzz targets for the decoders of untrusted bytes. Run one with go test -fuzz=FuzzSignature (the corpus it finds goes to
+// testdata/fuzz). Decoders must not panic on any input, and an input they accept must be the encoding the matching
+// encoder produces, so a value has exactly one encoding on the wire. A crash inside the bindings kills the fuzzing
+// worker, which go test reports like a panic, with the input that caused it.
+
+// fuzzFixture is a key pair, a signature and two blocks of one transaction, derived from a fixed seed. The first block
+// is signed by a single orderer and the second by a quorum of consenters, so it lists its signers.
+func fuzzFixture(f *testing.F) (*blschia.G1Element, *blschia.G2Element, *Transaction, []*Block) {
+	scheme := blschia.NewAugSchemeMPL()
+	sk, err := scheme.KeyGen(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		f.Fatal(err)
+	}
+	pk, _ := sk.G1Element()
+	proposal := NewProposalEnvelope("upi-p2p", []byte("fuzz"), 0).Bytes()
+	tx := &Transaction{Proposal: proposal, Endorsement: scheme.Sign(sk, proposal)}
+	block := NewBlock(nil, []*Transaction{tx})
+	block.Sign(scheme, sk)
+	clustered := NewBlock(&block.Header, []*Transaction{tx})
+	signers := NewSignerBitmap(5)
+	signers.Set(0)
+	signers.Set(2)
+	signers.Set(3)
+	header := scheme.Sign(sk, clustered.Header.Bytes())
+	clustered.SignQuorum(scheme, signers, []*blschia.G2Element{header, header, header})
+	return pk, tx.Endorsement, tx, []*Block{block, clustered}
+}
+
+// fuzzMutations seeds the corpus with valid and the ways it is usually broken: truncated, extended and with the
+// compression and infinity flags flipped
+func fuzzMutations(f *testing.F, valid []byte) {
+	f.Add(valid)
+	f.Add(valid[:len(valid)-1])
+	f.Add(append(bytes.Clone(valid), 0))
+	for _, flag := range []byte{0x80, infinityFlag, 0x20} {
+		flipped := bytes.Clone(valid)
+		flipped[0] ^= flag
+		f.Add(flipped)
+	}
+	f.Add([]byte{})
+}
+
+func FuzzPublicKey(f *testing.F) {
+	pk, _, _, _ := fuzzFixture(f)
+	fuzzMutations(f, pk.Serialize())
+	infinity := make([]byte, 48)
+	infinity[0] = 0xc0
+	f.Add(infinity)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pk, err := ParseBLSBytes[*blschia.G1Element](data)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(pk.Serialize(), data) {
+			t.Fatalf("accepted %x, serialized as %x", data, pk.Serialize())
+		}
+		if decoded, err := ParseBLSHex[*blschia.G1Element](BLSHex(pk)); err != nil || !decoded.EqualTo(pk) {
+			t.Fatalf("hex of an accepted key did not decode: %v", err)
+		}
+	})
+}
+
+func FuzzSignature(f *testing.F) {
+	_, sig, _, _ := fuzzFixture(f)
+	fuzzMutations(f, sig.Serialize())
+	infinity := make([]byte, 96)
+	infinity[0] = 0xc0
+	f.Add(infinity)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sig, err := ParseBLSBytes[*blschia.G2Element](data)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(sig.Serialize(), data) {
+			t.Fatalf("accepted %x, serialized as %x", data, sig.Serialize())
+		}
+		if decoded, err := ParseBLSBase64[*blschia.G2Element](BLSBase64(sig)); err != nil || !decoded.EqualTo(sig) {
+			t.Fatalf("base64 of an accepted signature did not decode: %v", err)
+		}
+	})
+}
+
+// FuzzBLSDocument feeds the same bytes to the JSON and CBOR decoders of both point types
+func FuzzBLSDocument(f *testing.F) {
+	pk, sig, _, _ := fuzzFixture(f)
+	for _, scheme := range []string{SchemeBasic, SchemeAug, SchemePop} {
+		pk_json, _ := BLSJSON(pk, scheme)
+		sig_cbor, _ := BLSCBOR(sig, scheme)
+		f.Add(pk_json)
+		f.Add(sig_cbor)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if pk, scheme, err := ParseBLSJSON[*blschia.G1Element](data); err == nil {
+			if encoded, _ := BLSJSON(pk, scheme); !bytes.Equal(encoded, data) {
+				t.Fatalf("accepted %q, encoded as %q", data, encoded)
+			}
+		}
+		if sig, scheme, err := ParseBLSJSON[*blschia.G2Element](data); err == nil {
+			if encoded, _ := BLSJSON(sig, scheme); !bytes.Equal(encoded, data) {
+				t.Fatalf("accepted %q, encoded as %q", data, encoded)
+			}
+		}
+		if pk, scheme, err := ParseBLSCBOR[*blschia.G1Element](data); err == nil {
+			if encoded, _ := BLSCBOR(pk, scheme); !bytes.Equal(encoded, data) {
+				t.Fatalf("accepted %x, encoded as %x", data, encoded)
+			}
+		}
+		if sig, scheme, err := ParseBLSCBOR[*blschia.G2Element](data); err == nil {
+			if encoded, _ := BLSCBOR(sig, scheme); !bytes.Equal(encoded, data) {
+				t.Fatalf("accepted %x, encoded as %x", data, encoded)
+			}
+		}
+	})
+}
+
+// FuzzTransaction feeds the same bytes to the wire and Fabric decoders of an endorsed transaction
+func FuzzTransaction(f *testing.F) {
+	_, _, tx, _ := fuzzFixture(f)
+	fuzzMutations(f, EncodeTransaction(tx))
+	listed := *tx
+	listed.Endorsers = []string{"NPCI", "RBI"}
+	for _, tx := range []*Transaction{tx, &listed} {
+		envelope, err := FabricEnvelope(tx, nil)
+		if err != nil {
+			f.Fatal(err)
+		}
+		fuzzMutations(f, envelope)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if tx, err := DecodeTransaction(data); err == nil {
+			if encoded := EncodeTransaction(tx); !bytes.Equal(encoded, data) {
+				t.Fatalf("accepted %x, encoded as %x", data, encoded)
+			}
+		}
+		if tx, creator, err := DecodeFabricEnvelope(data); err == nil {
+			if encoded, err := FabricEnvelope(tx, creator); err != nil || !bytes.Equal(encoded, data) {
+				t.Fatalf("accepted %x, encoded as %x (%v)", data, encoded, err)
+			}
+		}
+	})
+}
+
+// FuzzBlock feeds the same bytes to the wire and Fabric decoders of a block
+func FuzzBlock(f *testing.F) {
+	_, _, _, blocks := fuzzFixture(f)
+	for _, block := range blocks {
+		fuzzMutations(f, EncodeBlock(block))
+		encoded, err := FabricBlock(block)
+		if err != nil {
+			f.Fatal(err)
+		}
+		fuzzMutations(f, encoded)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if block, err := DecodeBlock(data); err == nil {
+			if encoded := EncodeBlock(block); !bytes.Equal(encoded, data) {
+				t.Fatalf("accepted %x, encoded as %x", data, encoded)
+			}
+		}
+		if block, err := DecodeFabricBlock(data); err == nil {
+			if encoded, err := FabricBlock(block); err != nil || !bytes.Equal(encoded, data) {
+				t.Fatalf("accepted %x, encoded as %x (%v)", data, encoded, err)
+			}
+		}
+	})
+}
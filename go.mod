@@ -2,4 +2,20 @@ module github.com/arun5309/chia
 
 go 1.22.3
 
-require github.com/dashpay/bls-signatures/go-bindings v0.0.0-20240215055916-1c2fc79c19dc
+require (
+	github.com/dashpay/bls-signatures/go-bindings v0.0.0-20240215055916-1c2fc79c19dc
+	github.com/fxamacker/cbor/v2 v2.7.0
+	go.etcd.io/etcd/raft/v3 v3.5.17
+	google.golang.org/grpc v1.64.0
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/golang/protobuf v1.5.4 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/net v0.22.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+)
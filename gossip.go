@@ -0,0 +1,614 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Gossip dissemination of blocks among peers, so the orderer only has to send each block to a few of them.
+// A peer verifies the aggregate signature of a block before committing and forwarding it (push to a few random
+// neighbours) and periodically tells a random neighbour how many blocks it has, which makes the neighbour push the
+// missing ones or ask for its own (pull). Every invalid block costs its sender reputation, and once that falls below
+// the ban score the sender's messages are dropped without paying for another verification. Reputation is only kept for
+// senders whose name the transport vouches for, otherwise anyone could get an honest peer banned by using its name.
+
+type GossipConfig struct {
+	Fanout       int           // Neighbours a new block is pushed to
+	PullInterval time.Duration // Period of the digests sent to a random neighbour
+	MaxPull      int           // Blocks sent in reply to a digest and held out of order
+	Reward       int           // Reputation gained for a new valid block
+	Penalty      int           // Reputation lost for an invalid block or malformed message
+	MaxScore     int
+	BanScore     int // Reputation below which a sender is ignored
+}
+
+var DefaultGossipConfig = GossipConfig{Fanout: 3, PullInterval: 100 * time.Millisecond, MaxPull: 16, Reward: 1, Penalty: 5, MaxScore: 20, BanScore: -10}
+
+// GossipMessage pushes a block or, when Block is nil, tells the receiver the sender has Height blocks
+type GossipMessage struct {
+	From          string
+	Authenticated bool // From was established by the transport rather than declared by the sender
+	Block         *Block
+	Height        uint64
+}
+
+var ErrGossipIdentity = errors.New("gossip: hello does not match the client certificate")
+
+// GossipTransport carries gossip messages to peers by name (over the simulated network or framed TCP)
+type GossipTransport interface {
+	Send(to string, msg *GossipMessage) error
+}
+
+type GossipMetrics struct {
+	BlocksReceived uint64
+	Duplicates     uint64 // Blocks already committed or held, dropped before verification
+	Invalid        uint64 // Blocks failing verification
+	Banned         uint64 // Messages dropped because their sender is banned
+	Pushed         uint64 // Blocks sent to neighbours
+	Digests        uint64 // Digests sent
+}
+
+func (m GossipMetrics) String() string {
+	return fmt.Sprintf("%d blocks received (%d duplicate, %d invalid), %d messages from banned peers, %d blocks pushed, %d digests",
+		m.BlocksReceived, m.Duplicates, m.Invalid, m.Banned, m.Pushed, m.Digests)
+}
+
+type GossipPeer struct {
+	name       string
+	scheme     SignatureScheme
+	config     *ChannelConfig
+	gossip     GossipConfig
+	transport  GossipTransport
+	neighbours []string
+	inbox      chan *GossipMessage
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	// Owned by the run goroutine
+	rng     *mrand.Rand
+	pending map[uint64]*GossipMessage // Verified blocks received out of order
+
+	mu         sync.Mutex
+	blocks     []*Block
+	update     chan struct{}
+	reputation map[string]int
+	metrics    GossipMetrics
+}
+
+func NewGossipPeer(name string, scheme SignatureScheme, config *ChannelConfig, transport GossipTransport, neighbours []string, gossip GossipConfig) *GossipPeer {
+	return &GossipPeer{
+		name:       name,
+		scheme:     scheme,
+		config:     config,
+		gossip:     gossip,
+		transport:  transport,
+		neighbours: neighbours,
+		inbox:      make(chan *GossipMessage, 1024),
+		stop:       make(chan struct{}),
+		rng:        mrand.New(mrand.NewSource(time.Now().UnixNano())),
+		pending:    make(map[uint64]*GossipMessage),
+		update:     make(chan struct{}),
+		reputation: make(map[string]int),
+	}
+}
+
+func (p *GossipPeer) Start() {
+	go p.run()
+}
+
+func (p *GossipPeer) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// Deliver hands a message that arrived from a transport to the peer, dropping it when the peer is overloaded
+func (p *GossipPeer) Deliver(msg *GossipMessage) {
+	select {
+	case p.inbox <- msg:
+	default:
+	}
+}
+
+// Blocks returns the committed blocks from number start onwards and a channel closed when the next block is committed
+func (p *GossipPeer) Blocks(start uint64) ([]*Block, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.blocks[min(start, uint64(len(p.blocks))):], p.update
+}
+
+func (p *GossipPeer) Reputation(name string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reputation[name]
+}
+
+func (p *GossipPeer) Banned(name string) bool {
+	return p.Reputation(name) < p.gossip.BanScore
+}
+
+func (p *GossipPeer) Metrics() GossipMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.metrics
+}
+
+// Penalise lowers the reputation of a sender, e.g. for a message that could not be decoded
+func (p *GossipPeer) Penalise(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reputation[name] -= p.gossip.Penalty
+}
+
+func (p *GossipPeer) reward(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reputation[name] = min(p.reputation[name]+p.gossip.Reward, p.gossip.MaxScore)
+}
+
+func (p *GossipPeer) count(counter *uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	*counter++
+}
+
+func (p *GossipPeer) height() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return uint64(len(p.blocks))
+}
+
+func (p *GossipPeer) run() {
+	ticker := time.NewTicker(p.gossip.PullInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case msg := <-p.inbox:
+			p.handle(msg)
+		case <-ticker.C:
+			if to := p.pick(1, ""); len(to) > 0 {
+				p.send(to[0], &GossipMessage{Height: p.height()}, &p.metrics.Digests)
+			}
+		}
+	}
+}
+
+func (p *GossipPeer) handle(msg *GossipMessage) {
+	if msg.Authenticated && p.Banned(msg.From) {
+		p.count(&p.metrics.Banned)
+		return
+	}
+	height := p.height()
+	if msg.Block == nil {
+		// Push what the sender misses or ask for what we miss
+		if msg.Height < height {
+			blocks, _ := p.Blocks(msg.Height)
+			for _, block := range blocks[:min(len(blocks), p.gossip.MaxPull)] {
+				p.send(msg.From, &GossipMessage{Block: block}, &p.metrics.Pushed)
+			}
+		} else if msg.Height > height {
+			p.send(msg.From, &GossipMessage{Height: height}, &p.metrics.Digests)
+		}
+		return
+	}
+
+	p.count(&p.metrics.BlocksReceived)
+	number := msg.Block.Header.Number
+	if number < height || p.pending[number] != nil {
+		p.count(&p.metrics.Duplicates)
+		return
+	}
+	if number >= height+uint64(p.gossip.MaxPull) {
+		// Too far ahead to hold, the block will be pulled once the gap closes
+		return
+	}
+	if !p.config.VerifyBlock(p.scheme, msg.Block) {
+		p.count(&p.metrics.Invalid)
+		if msg.Authenticated {
+			p.Penalise(msg.From)
+		}
+		return
+	}
+	if msg.Authenticated {
+		p.reward(msg.From)
+	}
+	p.pending[number] = msg
+	p.commit()
+}
+
+// commit appends the held blocks that extend the chain and forwards them
+func (p *GossipPeer) commit() {
+	for {
+		height := p.height()
+		msg := p.pending[height]
+		if msg == nil {
+			return
+		}
+		delete(p.pending, height)
+		var previousHash []byte
+		if height > 0 {
+			blocks, _ := p.Blocks(height - 1)
+			previousHash = blocks[0].Header.Hash()
+		}
+		if !bytes.Equal(msg.Block.Header.PreviousHash, previousHash) {
+			// Signed by the orderer but not on our chain, not the sender's fault
+			continue
+		}
+		p.mu.Lock()
+		p.blocks = append(p.blocks, msg.Block)
+		close(p.update)
+		p.update = make(chan struct{})
+		p.mu.Unlock()
+		for _, to := range p.pick(p.gossip.Fanout, msg.From) {
+			p.send(to, &GossipMessage{Block: msg.Block}, &p.metrics.Pushed)
+		}
+	}
+}
+
+// pick returns up to n random neighbours that are not banned, leaving out except
+func (p *GossipPeer) pick(n int, except string) []string {
+	var candidates []string
+	for _, neighbour := range p.neighbours {
+		if neighbour != except && !p.Banned(neighbour) {
+			candidates = append(candidates, neighbour)
+		}
+	}
+	p.rng.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	return candidates[:min(n, len(candidates))]
+}
+
+func (p *GossipPeer) send(to string, msg *GossipMessage, counter *uint64) {
+	msg.From = p.name
+	if p.transport.Send(to, msg) == nil {
+		p.count(counter)
+	}
+}
+
+// Gossip over the simulated network of simulator.go
+
+type SimGossipTransport struct {
+	network *SimNetwork
+	from    string
+	inbox   <-chan *SimMessage
+}
+
+// NewSimGossipTransport registers name with the simulated network
+func NewSimGossipTransport(network *SimNetwork, name string) *SimGossipTransport {
+	return &SimGossipTransport{network: network, from: name, inbox: network.AddNode(name)}
+}
+
+func (t *SimGossipTransport) Send(to string, msg *GossipMessage) error {
+	t.network.Send(&SimMessage{From: t.from, To: to, Kind: SimGossip, Block: msg.Block, Payload: binary.BigEndian.AppendUint64(nil, msg.Height)})
+	return nil
+}
+
+// Serve delivers the gossip messages arriving for the node to peer until the network stops
+func (t *SimGossipTransport) Serve(peer *GossipPeer) {
+	for {
+		select {
+		case msg := <-t.inbox:
+			if msg.Kind == SimGossip && len(msg.Payload) == 8 {
+				peer.Deliver(&GossipMessage{From: msg.From, Authenticated: true, Block: msg.Block, Height: binary.BigEndian.Uint64(msg.Payload)})
+			}
+		case <-t.network.Done():
+			return
+		}
+	}
+}
+
+// Gossip over framed TCP (wire.go). Every peer opens its own connection to each neighbour it sends to and introduces
+// itself with a hello frame. With mutual TLS the name must be the common name of the client certificate, otherwise it is
+// only the sender's claim and its messages do not count towards any reputation.
+
+type WireGossipTransport struct {
+	from      string
+	addrs     map[string]string
+	tlsConfig *tls.Config
+	mu        sync.Mutex
+	conns     map[string]*WireConn
+}
+
+func NewWireGossipTransport(from string, addrs map[string]string, tlsConfig *tls.Config) *WireGossipTransport {
+	return &WireGossipTransport{from: from, addrs: addrs, tlsConfig: tlsConfig, conns: make(map[string]*WireConn)}
+}
+
+func (t *WireGossipTransport) Send(to string, msg *GossipMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conn := t.conns[to]
+	if conn == nil {
+		addr, found := t.addrs[to]
+		if !found {
+			return fmt.Errorf("gossip: no address for %s", to)
+		}
+		var err error
+		if conn, err = DialWire(addr, t.tlsConfig); err != nil {
+			return err
+		}
+		if err := conn.WriteFrame(FrameGossipHello, []byte(t.from)); err != nil {
+			conn.Close()
+			return err
+		}
+		t.conns[to] = conn
+	}
+	var err error
+	if msg.Block != nil {
+		err = conn.WriteFrame(FrameBlock, EncodeBlock(msg.Block))
+	} else {
+		err = conn.WriteFrame(FrameGossipDigest, binary.BigEndian.AppendUint64(nil, msg.Height))
+	}
+	if err != nil {
+		conn.Close()
+		delete(t.conns, to)
+	}
+	return err
+}
+
+func (t *WireGossipTransport) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for to, conn := range t.conns {
+		conn.Close()
+		delete(t.conns, to)
+	}
+}
+
+// ServeWireGossip delivers the gossip frames of every accepted connection to peer. A malformed frame closes the
+// connection and costs the sender reputation when its name is authenticated.
+func ServeWireGossip(listener net.Listener, peer *GossipPeer) error {
+	return serveWire(listener, func(conn *WireConn) error {
+		hello, err := conn.readExpected(FrameGossipHello)
+		if err != nil {
+			return err
+		}
+		from := string(hello)
+		authenticated := false
+		if tlsConn, ok := conn.conn.(*tls.Conn); ok {
+			if state := tlsConn.ConnectionState(); len(state.VerifiedChains) > 0 {
+				if state.PeerCertificates[0].Subject.CommonName != from {
+					return fmt.Errorf("%w: %q", ErrGossipIdentity, from)
+				}
+				authenticated = true
+			}
+		}
+		for {
+			t, payload, err := conn.ReadFrame()
+			if err != nil {
+				return err
+			}
+			msg := &GossipMessage{From: from, Authenticated: authenticated}
+			switch {
+			case t == FrameBlock:
+				msg.Block, err = DecodeBlock(payload)
+			case t == FrameGossipDigest && len(payload) == 8:
+				msg.Height = binary.BigEndian.Uint64(payload)
+			default:
+				err = ErrUnexpectedFrame
+			}
+			if err != nil {
+				if authenticated {
+					peer.Penalise(from)
+				}
+				return err
+			}
+			peer.Deliver(msg)
+		}
+	})
+}
+
+// loopbackPeerTLS returns a mutual TLS configuration for each of names, around certificates for 127.0.0.1 with the
+// name as common name issued by a fresh CA (for loopback experiments)
+func loopbackPeerTLS(names []string) (map[string]*tls.Config, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gossip CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	if ca, err = x509.ParseCertificate(der); err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	configs := make(map[string]*tls.Config)
+	for i, name := range names {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}, ca, &key.PublicKey, caKey)
+		if err != nil {
+			return nil, err
+		}
+		configs[name] = &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+			RootCAs:      pool,
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS13,
+		}
+	}
+	return configs, nil
+}
+
+// gossipNeighbours connects peer i of n to the next two around a ring and one more at random
+func gossipNeighbours(names []string, rng *mrand.Rand) map[string][]string {
+	neighbours := make(map[string][]string)
+	link := func(a, b string) {
+		for _, existing := range neighbours[a] {
+			if existing == b {
+				return
+			}
+		}
+		neighbours[a] = append(neighbours[a], b)
+		neighbours[b] = append(neighbours[b], a)
+	}
+	for i, name := range names {
+		link(name, names[(i+1)%len(names)])
+		link(name, names[(i+2)%len(names)])
+		if j := rng.Intn(len(names)); j != i {
+			link(name, names[j])
+		}
+	}
+	return neighbours
+}
+
+// The orderer hands each block to one peer and gossip spreads it to the others, while a malicious peer pushes
+// tampered blocks, first over the simulated network and then over loopback TCP with mutual TLS
+func GossipExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	chain := exampleChain(scheme, config, sks, orderer_sk, 8, 3)
+	last := chain[len(chain)-1]
+	tampered := *last
+	tampered.Transactions = append([]*Transaction{{Proposal: []byte("pay mallory 1000000 INR"), Endorsement: last.Transactions[0].Endorsement}},
+		last.Transactions[1:]...)
+
+	names := []string{"peer0", "peer1", "peer2", "peer3", "peer4", "peer5", "peer6", "peer7"}
+	rng := mrand.New(mrand.NewSource(1))
+	neighbours := gossipNeighbours(names, rng)
+	for _, name := range names[:3] {
+		neighbours[name] = append(neighbours[name], "mallory")
+	}
+
+	// newTransport is called for every node before serve is called for the peers
+	run := func(label string, newTransport func(name string) GossipTransport, serve func(name string, peer *GossipPeer)) {
+		orderer := newTransport("orderer")
+		mallory := newTransport("mallory")
+		peers := make(map[string]*GossipPeer)
+		for _, name := range names {
+			peers[name] = NewGossipPeer(name, scheme, config, newTransport(name), neighbours[name], DefaultGossipConfig)
+		}
+		for name, peer := range peers {
+			go serve(name, peer)
+			peer.Start()
+			defer peer.Stop()
+		}
+
+		deadline := time.After(10 * time.Second)
+		for i := 0; i < 3; i++ {
+			for _, name := range names[:3] {
+				mallory.Send(name, &GossipMessage{From: "mallory", Block: &tampered})
+			}
+		}
+		for _, name := range names[:3] {
+			for !peers[name].Banned("mallory") {
+				select {
+				case <-time.After(time.Millisecond):
+				case <-deadline:
+					panic(fmt.Sprintf("%s: %s did not ban mallory", label, name))
+				}
+			}
+			// Dropped without verification from now on
+			mallory.Send(name, &GossipMessage{From: "mallory", Block: &tampered})
+		}
+		for _, block := range chain {
+			orderer.Send(names[rng.Intn(len(names))], &GossipMessage{From: "orderer", Block: block})
+		}
+
+		var metrics GossipMetrics
+		for _, name := range names {
+			for {
+				blocks, update := peers[name].Blocks(0)
+				if len(blocks) == len(chain) {
+					break
+				}
+				select {
+				case <-update:
+				case <-deadline:
+					panic(fmt.Sprintf("%s: %s only has %d of %d blocks", label, name, len(blocks), len(chain)))
+				}
+			}
+			m := peers[name].Metrics()
+			metrics.BlocksReceived += m.BlocksReceived
+			metrics.Duplicates += m.Duplicates
+			metrics.Invalid += m.Invalid
+			metrics.Banned += m.Banned
+			metrics.Pushed += m.Pushed
+			metrics.Digests += m.Digests
+		}
+		fmt.Printf("gossip over %s: %v\n", label, metrics)
+	}
+
+	network := NewSimNetwork(LinkConfig{Latency: 5 * time.Millisecond, Jitter: 2 * time.Millisecond, Bandwidth: 10 << 20})
+	transports := make(map[string]*SimGossipTransport)
+	run("simulated network", func(name string) GossipTransport {
+		transports[name] = NewSimGossipTransport(network, name)
+		return transports[name]
+	}, func(name string, peer *GossipPeer) {
+		transports[name].Serve(peer)
+	})
+	network.Stop()
+
+	tlsConfigs, err := loopbackPeerTLS(append([]string{"orderer", "mallory"}, names...))
+	if err != nil {
+		panic(err)
+	}
+	addrs := make(map[string]string)
+	listeners := make(map[string]net.Listener)
+	var wireTransports []*WireGossipTransport
+	run("loopback TCP", func(name string) GossipTransport {
+		listener, err := ListenWire("127.0.0.1:0", tlsConfigs[name])
+		if err != nil {
+			panic(err)
+		}
+		listeners[name] = listener
+		addrs[name] = listener.Addr().String()
+		transport := NewWireGossipTransport(name, addrs, tlsConfigs[name])
+		wireTransports = append(wireTransports, transport)
+		return transport
+	}, func(name string, peer *GossipPeer) {
+		ServeWireGossip(listeners[name], peer)
+	})
+
+	// Mallory's certificate does not let it speak for another peer
+	impostor, err := DialWire(addrs["peer0"], tlsConfigs["mallory"])
+	if err != nil {
+		panic(err)
+	}
+	impostor.WriteFrame(FrameGossipHello, []byte("peer3"))
+	if _, _, err := impostor.ReadFrame(); err == nil {
+		panic("accepted a hello for another peer's name")
+	}
+	impostor.Close()
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	for _, transport := range wireTransports {
+		transport.Close()
+	}
+}
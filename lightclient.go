@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// LightClient follows a channel by block headers only. It trusts nothing but the channel configuration:
+// every header must carry a valid orderer signature and chain onto the previous header, and a single transaction
+// is accepted once its endorsement aggregate satisfies the policy and it is proven to be in a known block.
+type LightClient struct {
+	scheme  SignatureScheme
+	config  *ChannelConfig
+	headers []BlockHeader
+}
+
+var (
+	ErrHeaderSignature        = errors.New("lightclient: invalid orderer signature on header")
+	ErrHeaderOutOfOrder       = errors.New("lightclient: header number does not follow the last known header")
+	ErrHeaderChain            = errors.New("lightclient: header does not chain onto the last known header")
+	ErrUnknownBlock           = errors.New("lightclient: block header not known")
+	ErrTransactionNotIncluded = errors.New("lightclient: transaction is not included in the block")
+	ErrEndorsement            = errors.New("lightclient: endorsement does not satisfy the endorsement policy")
+)
+
+func NewLightClient(scheme SignatureScheme, config *ChannelConfig) *LightClient {
+	return &LightClient{scheme: scheme, config: config}
+}
+
+// Height is the number of headers known
+func (lc *LightClient) Height() uint64 {
+	return uint64(len(lc.headers))
+}
+
+func (lc *LightClient) Header(number uint64) (*BlockHeader, bool) {
+	if number >= lc.Height() {
+		return nil, false
+	}
+	return &lc.headers[number], true
+}
+
+// AddHeader appends the next header of the chain after checking its orderer signature
+func (lc *LightClient) AddHeader(header BlockHeader, ordererSig *blschia.G2Element) error {
+	return lc.AddClusterHeader(header, ordererSig, nil)
+}
+
+// AddClusterHeader is AddHeader for a channel ordered by a cluster: ordererSig aggregates the signatures of the signers
+func (lc *LightClient) AddClusterHeader(header BlockHeader, ordererSig *blschia.G2Element, signers SignerBitmap) error {
+	if header.Number != lc.Height() {
+		return ErrHeaderOutOfOrder
+	}
+	if header.Number > 0 && !bytes.Equal(header.PreviousHash, lc.headers[header.Number-1].Hash()) {
+		return ErrHeaderChain
+	}
+	ordererPks, err := lc.config.OrdererKeys(signers)
+	if err != nil || !VerifyHeader(lc.scheme, ordererPks, &header, ordererSig) {
+		return ErrHeaderSignature
+	}
+	if err := lc.config.CheckRevoked(ordererPks, header.Number); err != nil {
+		return err
+	}
+	lc.headers = append(lc.headers, header)
+	return nil
+}
+
+// VerifyTransaction checks that tx is in block number (using proof) and that its endorsement satisfies the policy
+func (lc *LightClient) VerifyTransaction(number uint64, tx *Transaction, proof *MerkleProof) error {
+	header, found := lc.Header(number)
+	if !found {
+		return ErrUnknownBlock
+	}
+	if !VerifyInclusion(header, tx.Proposal, proof) {
+		return ErrTransactionNotIncluded
+	}
+	if !lc.config.VerifyEndorsement(lc.scheme, tx) {
+		return ErrEndorsement
+	}
+	if err := lc.config.CheckEndorsers(number); err != nil {
+		return err
+	}
+	return nil
+}
+
+// A light client syncing headers from a full peer and checking one UPI transfer without downloading any block body
+func LightClientExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+
+	// Blocks produced by the orderer and held by full peers
+	blocks := exampleChain(scheme, config, sks, orderer_sk, 3, 4)
+	previous := &blocks[2].Header
+
+	client := NewLightClient(scheme, config)
+	for _, block := range blocks {
+		if err := client.AddHeader(block.Header, block.OrdererSignature); err != nil {
+			panic(err)
+		}
+	}
+
+	// The full peer serves one transaction with its proof
+	tx := blocks[1].Transactions[2]
+	proof, _ := blocks[1].InclusionProof(2)
+	if err := client.VerifyTransaction(1, tx, proof); err != nil {
+		panic(err)
+	}
+
+	// Forged endorsement (missing HDFC) must be rejected
+	forged := &Transaction{Proposal: tx.Proposal, Endorsement: scheme.AggregateSigs(scheme.Sign(sks["NPCI"], tx.Proposal), scheme.Sign(sks["RBI"], tx.Proposal), scheme.Sign(sks["SBI"], tx.Proposal))}
+	if client.VerifyTransaction(1, forged, proof) != ErrEndorsement {
+		panic("light client accepted an endorsement not satisfying the policy")
+	}
+	// A header signed by someone other than the orderer must be rejected
+	fake := NewBlock(previous, blocks[0].Transactions)
+	fake.Sign(scheme, sks["SBI"])
+	if client.AddHeader(fake.Header, fake.OrdererSignature) != ErrHeaderSignature {
+		panic("light client accepted a header not signed by the orderer")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"github.com/dashpay/bls-signatures/go-bindings" // Module blschia (make sure to compile it and have its path in the environment variables CGO_CXXFLAGS and CGO_LDFLAGS. blschia also has interesting benchmarks but its for the c++ version)
+	"os"
 )
 
 /*
@@ -82,13 +83,13 @@ func OurProposalAugExample() {
 	hdfc_pk, _ := hdfc_sk.G1Element()
 	orderer_pk, _ := orderer_sk.G1Element()
 
-	// Creating fake transaction proposals (Assuming payload size is about 5000 bytes).
-	// Here, proposal is assumed to contain the configuration policy as well.
+	// Creating transaction proposals (canonical encoding of UPI payments, see upi.go).
+	// Here, proposal contains a reference to the configuration policy as well.
 	// For the sake of simplicity we assume things like endorsers/orderer doesn't add any data (does not include signatures and stuff like that) to the proposal'
-	proposal1, _ := makeRandomArray(5000) // Say for SBI to HDFC transfer
+	proposal1 := examplePayment("SBI", "HDFC", 1500_00).Bytes() // SBI to HDFC transfer
 	// SBI client sends proposal1 to other endorsers i.e NPCI, RBI, (SBI), HDFC
 
-	proposal2, _ := makeRandomArray(5000) // Say for HDFC to SBI transfer
+	proposal2 := examplePayment("HDFC", "SBI", 420_50).Bytes() // HDFC to SBI transfer
 	// HDFC client sends proposal2 to other endorsers i.e NPCI, RBI, SBI, (HDFC)
 
 	// Creating endorsements for proposals
@@ -172,8 +173,8 @@ func OurProposalAugExample() {
 	// The orderer sends block_payload and block_sign to peers for committing
 
 	// Peer verification (needs to be run by each peer).
-	txn1_payload := block_payload[:5000]
-	txn2_payload := block_payload[5000:]
+	txn1_payload := block_payload[:len(proposal1)]
+	txn2_payload := block_payload[len(proposal1):]
 	// Check for ordering effects in below for performance in hot and cold paths (it probably doesn't make a big difference)'
 	ok = scheme.AggregateVerify([]*blschia.G1Element{orderer_pk, npci_pk, rbi_pk, sbi_pk, hdfc_pk, npci_pk, rbi_pk, sbi_pk, hdfc_pk}, [][]byte{block_payload, txn1_payload, txn1_payload, txn1_payload, txn1_payload, txn2_payload, txn2_payload, txn2_payload, txn2_payload}, block_sign)
 
@@ -237,13 +238,13 @@ func OurProposalPopExample() {
 		panic("Orderer misusing proof of possession scheme. Not trust Orderer's key.")
 	}
 
-	// Creating fake transaction proposals (Assuming payload size is about 5000 bytes).
-	// Here, proposal is assumed to contain the configuration policy as well.
+	// Creating transaction proposals (canonical encoding of UPI payments, see upi.go).
+	// Here, proposal contains a reference to the configuration policy as well.
 	// For the sake of simplicity we assume things like endorsers/orderer doesn't add any data (does not include signatures and stuff like that) to the proposal'
-	proposal1, _ := makeRandomArray(5000) // Say for SBI to HDFC transfer
+	proposal1 := examplePayment("SBI", "HDFC", 1500_00).Bytes() // SBI to HDFC transfer
 	// SBI client sends proposal1 to other endorsers i.e NPCI, RBI, (SBI), HDFC
 
-	proposal2, _ := makeRandomArray(5000) // Say for HDFC to SBI transfer
+	proposal2 := examplePayment("HDFC", "SBI", 420_50).Bytes() // HDFC to SBI transfer
 	// HDFC client sends proposal2 to other endorsers i.e NPCI, RBI, SBI, (HDFC)
 
 	// Creating endorsements for proposals
@@ -330,8 +331,8 @@ func OurProposalPopExample() {
 	// The orderer sends block_payload and block_sign to peers for committing
 
 	// Peer verification (needs to be run by each peer).
-	txn1_payload := block_payload[:5000]
-	txn2_payload := block_payload[5000:]
+	txn1_payload := block_payload[:len(proposal1)]
+	txn2_payload := block_payload[len(proposal1):]
 	// Check for ordering effects in below for performance in hot and cold paths (it probably doesn't make a big difference)'
 	ok = scheme.AggregateVerify([]*blschia.G1Element{orderer_pk, npci_pk, rbi_pk, sbi_pk, hdfc_pk, npci_pk, rbi_pk, sbi_pk, hdfc_pk}, [][]byte{block_payload, txn1_payload, txn1_payload, txn1_payload, txn1_payload, txn2_payload, txn2_payload, txn2_payload, txn2_payload}, block_sign)
 
@@ -376,10 +377,10 @@ func Scratch() {
 	var aggSig = scheme.AggregateSigs(sig1, sig2)
 
 	fmt.Println("Seed: ", seed)
-	ser := aggSig.Serialize()
+	ser := BLSHex(aggSig)
 	fmt.Println("Aggregate signature: ", ser)
-	deser, _ := blschia.G2ElementFromBytes(ser)
-	fmt.Println("Deserialized: ", deser)
+	deser, _ := ParseBLSHex[*blschia.G2Element](ser)
+	fmt.Println("Deserialized: ", BLSHex(deser))
 	fmt.Println("Merged: ", append(msg1, msg2[:]...))
 	fmt.Println("pk size: ", len(pk1.Serialize()))
 	fmt.Println("sk size: ", len(sk1.Serialize()))
@@ -424,10 +425,10 @@ func PopScratch() {
 	var aggSig = scheme.AggregateSigs(sig1, sig2)
 
 	fmt.Println("Seed: ", seed)
-	ser := aggSig.Serialize()
+	ser := BLSHex(aggSig)
 	fmt.Println("Aggregate signature: ", ser)
-	deser, _ := blschia.G2ElementFromBytes(ser)
-	fmt.Println("Deserialized: ", deser)
+	deser, _ := ParseBLSHex[*blschia.G2Element](ser)
+	fmt.Println("Deserialized: ", BLSHex(deser))
 	fmt.Println("Merged: ", append(msg1, msg2[:]...))
 	fmt.Println("pk size: ", len(pk1.Serialize()))
 	fmt.Println("sk size: ", len(sk1.Serialize()))
@@ -459,10 +460,37 @@ func PopScratch() {
 }
 
 func main() {
+	// chia <role> [flags] runs a standalone node (see nodes.go), otherwise run the examples
+	if len(os.Args) > 1 && runNode(os.Args[1:]) {
+		return
+	}
 	fmt.Println("Starting aggregate signatures benchmark!")
 	Scratch()
 	PopScratch()
 	OurProposalAugExample()
 	OurProposalPopExample()
+	DigestProposalAugExample()
+	MerkleBlockExample()
+	LightClientExample()
+	CheckpointExample()
+	NetworkSimulationExample()
+	GRPCExample()
+	WireExample()
+	OrdererExample()
+	StreamingAggregationExample()
+	RaftOrderingExample()
+	BFTOrderingExample()
+	GossipExample()
+	WorldStateExample()
+	UPIPaymentExample()
+	WorkloadExample()
+	ReplayProtectionExample()
+	MultiChannelExample()
+	ConfigTransactionExample()
+	RevocationExample()
+	CertificateIdentityExample()
+	FabricFormatExample()
+	FabricPluginExample()
+	CodecExample()
 	fmt.Println("Finishing aggregate signatures benchmark!")
 }
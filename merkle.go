@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// Merkle tree over the transactions of a block following the RFC 6962 construction:
+// leaves and interior nodes are hashed with different prefixes (so a node can never be passed off as a leaf)
+// and an unbalanced tree is split at the largest power of two below the number of leaves (so no leaf is ever duplicated).
+
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+var ErrMerkleIndex = errors.New("merkle: leaf index out of range")
+
+func merkleLeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Largest power of two strictly less than n (n > 1)
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func merkleRootOfHashes(hashes [][]byte) []byte {
+	switch len(hashes) {
+	case 0:
+		return sha256.New().Sum(nil)
+	case 1:
+		return hashes[0]
+	}
+	k := merkleSplit(len(hashes))
+	return merkleNodeHash(merkleRootOfHashes(hashes[:k]), merkleRootOfHashes(hashes[k:]))
+}
+
+func merkleLeafHashes(leaves [][]byte) [][]byte {
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = merkleLeafHash(leaf)
+	}
+	return hashes
+}
+
+// MerkleRoot returns the root of the tree over leaves (the hash of the empty string when there are no leaves)
+func MerkleRoot(leaves [][]byte) []byte {
+	return merkleRootOfHashes(merkleLeafHashes(leaves))
+}
+
+// MerkleProof is an inclusion proof of the leaf at Index in a tree of LeafCount leaves.
+// Path lists the sibling hashes from the leaf up to the root.
+type MerkleProof struct {
+	Index     int
+	LeafCount int
+	Path      [][]byte
+}
+
+func merklePath(hashes [][]byte, index int) [][]byte {
+	if len(hashes) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(hashes))
+	if index < k {
+		return append(merklePath(hashes[:k], index), merkleRootOfHashes(hashes[k:]))
+	}
+	return append(merklePath(hashes[k:], index-k), merkleRootOfHashes(hashes[:k]))
+}
+
+// NewMerkleProof builds the inclusion proof of leaves[index]
+func NewMerkleProof(leaves [][]byte, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, ErrMerkleIndex
+	}
+	return &MerkleProof{Index: index, LeafCount: len(leaves), Path: merklePath(merkleLeafHashes(leaves), index)}, nil
+}
+
+func merkleRootFromPath(hash []byte, index, count int, path [][]byte) ([]byte, bool) {
+	if count <= 1 {
+		return hash, len(path) == 0
+	}
+	if len(path) == 0 {
+		return nil, false
+	}
+	sibling := path[len(path)-1]
+	k := merkleSplit(count)
+	if index < k {
+		left, ok := merkleRootFromPath(hash, index, k, path[:len(path)-1])
+		return merkleNodeHash(left, sibling), ok
+	}
+	right, ok := merkleRootFromPath(hash, index-k, count-k, path[:len(path)-1])
+	return merkleNodeHash(sibling, right), ok
+}
+
+// VerifyMerkleProof checks that leaf is included at proof.Index in the tree with the given root
+func VerifyMerkleProof(root, leaf []byte, proof *MerkleProof) bool {
+	if proof == nil || proof.Index < 0 || proof.Index >= proof.LeafCount {
+		return false
+	}
+	computed, ok := merkleRootFromPath(merkleLeafHash(leaf), proof.Index, proof.LeafCount, proof.Path)
+	return ok && bytes.Equal(computed, root)
+}
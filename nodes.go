@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/arun5309/chia/chiapb"
+	"github.com/dashpay/bls-signatures/go-bindings"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Standalone nodes so that every role can run as a separate local process, e.g.
+//
+//	chia endorser -org NPCI -listen 127.0.0.1:7051 (likewise for RBI, SBI and HDFC on other ports)
+//	chia orderer -listen 127.0.0.1:7050
+//	chia peer -orderer 127.0.0.1:7050
+//	chia client -orderer 127.0.0.1:7050 -endorsers NPCI=127.0.0.1:7051,RBI=127.0.0.1:7052,SBI=127.0.0.1:7053,HDFC=127.0.0.1:7054
+//
+// The client replays a transaction log with -trace payments.csv (or .jsonl, see workload.go) instead of submitting random
+// proposals.
+//
+// -transport tcp switches every node to the framed TCP protocol of wire.go instead of gRPC. Servers take -tls-cert and -tls-key
+// and clients take -tls-ca to use TLS with either transport.
+//
+// Proposals are wrapped in envelopes for the -channel (see replay.go) which endorsers and the orderer check for replays.
+//
+// Keys are derived from the organisation names (demoKey) so that every process agrees on the channel configuration
+// without a key distribution step. This is only suitable for local experiments.
+
+// demoKey derives the key of an organisation from its name. Insecure: anyone knowing the name knows the key.
+func demoKey(scheme blschia.Scheme, name string) *blschia.PrivateKey {
+	seed := sha256.Sum256([]byte("chia-demo-key/" + name))
+	sk, err := scheme.KeyGen(seed[:])
+	if err != nil {
+		panic(err)
+	}
+	return sk
+}
+
+func demoChannel(scheme blschia.Scheme, channel string) *ChannelConfig {
+	config := &ChannelConfig{Members: make(map[string]*blschia.G1Element), Endorsers: exampleOrganisations, ChannelID: channel}
+	for _, name := range exampleOrganisations {
+		config.Members[name], _ = demoKey(scheme, name).G1Element()
+	}
+	config.Orderer, _ = demoKey(scheme, "orderer").G1Element()
+	return config
+}
+
+func schemeByName(name string) (blschia.Scheme, error) {
+	switch name {
+	case "aug":
+		return blschia.NewAugSchemeMPL(), nil
+	case "pop":
+		return blschia.NewPopSchemeMPL(), nil
+	}
+	return nil, fmt.Errorf("unknown scheme %q (expected aug or pop)", name)
+}
+
+// parseEndorsers parses NAME=ADDR,NAME=ADDR,...
+func parseEndorsers(value string) (map[string]string, error) {
+	addrs := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		name, addr, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid endorser %q (expected NAME=ADDR)", entry)
+		}
+		addrs[name] = addr
+	}
+	return addrs, nil
+}
+
+// loadTLS returns nil (plain TCP) unless certificate files are given
+func loadTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && caFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS13}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	return config, nil
+}
+
+// runNode runs the role named by args[0] and returns false if there is no such role
+func runNode(args []string) bool {
+	role := args[0]
+	flags := flag.NewFlagSet(role, flag.ExitOnError)
+	schemeName := flags.String("scheme", "pop", "signature scheme (aug or pop)")
+	channel := flags.String("channel", "upi", "channel ID proposals are bound to")
+	listen := flags.String("listen", "127.0.0.1:0", "address to listen on (endorser and orderer)")
+	org := flags.String("org", "", "organisation name (endorser)")
+	ordererAddr := flags.String("orderer", "127.0.0.1:7050", "orderer address (peer and client)")
+	endorserAddrs := flags.String("endorsers", "", "NAME=ADDR list of endorsers (client)")
+	blockSize := flags.Int("block-size", DefaultOrdererConfig.MaxTransactions, "maximum transactions per block (orderer)")
+	blockBytes := flags.Int("block-bytes", DefaultOrdererConfig.MaxBytes, "maximum proposal bytes per block, 0 for no limit (orderer)")
+	blockTimeout := flags.Duration("block-timeout", DefaultOrdererConfig.BatchTimeout, "batch timeout (orderer)")
+	count := flags.Int("count", 10, "number of transactions to submit (client)")
+	payloadSize := flags.Int("size", 5000, "proposal size in bytes (client)")
+	trace := flags.String("trace", "", "CSV or JSONL transaction log to replay instead of random proposals (client)")
+	speedup := flags.Float64("speedup", 1, "replay the trace this many times faster than recorded (client)")
+	transport := flags.String("transport", "grpc", "transport (grpc or tcp)")
+	tlsCert := flags.String("tls-cert", "", "TLS certificate file (endorser and orderer)")
+	tlsKey := flags.String("tls-key", "", "TLS key file (endorser and orderer)")
+	tlsCA := flags.String("tls-ca", "", "TLS CA certificate file to verify servers with (peer and client)")
+
+	switch role {
+	case "endorser", "orderer", "peer", "client":
+	default:
+		return false
+	}
+	flags.Parse(args[1:])
+	scheme, err := schemeByName(*schemeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config := demoChannel(scheme, *channel)
+	if *transport != "grpc" && *transport != "tcp" {
+		log.Fatalf("unknown transport %q (expected grpc or tcp)", *transport)
+	}
+	tlsConfig, err := loadTLS(*tlsCert, *tlsKey, *tlsCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listener := func(tlsConfig *tls.Config) net.Listener {
+		listener, err := ListenWire(*listen, tlsConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s listening on %s (%s)", role, listener.Addr(), *transport)
+		return listener
+	}
+	serveGRPC := func(register func(*grpc.Server)) {
+		var options []grpc.ServerOption
+		if tlsConfig != nil {
+			options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		server := grpc.NewServer(options...)
+		register(server)
+		log.Fatal(server.Serve(listener(nil)))
+	}
+	dialOrderer := func() OrdererClient {
+		if *transport == "tcp" {
+			orderer, err := NewWireOrdererClient(*ordererAddr, tlsConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return orderer
+		}
+		conn, err := dialGRPC(*ordererAddr, tlsConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return NewGRPCOrdererClient(conn)
+	}
+
+	switch role {
+	case "endorser":
+		if _, found := config.Members[*org]; !found {
+			log.Fatalf("unknown organisation %q", *org)
+		}
+		sk := demoKey(scheme, *org)
+		guard := NewReplayGuard(config.ChannelID, DefaultReplayCapacity, DefaultProposalTTL)
+		if *transport == "tcp" {
+			log.Fatal(ServeWireEndorser(listener(tlsConfig), *org, scheme, sk, guard))
+		}
+		serveGRPC(func(s *grpc.Server) { chiapb.RegisterEndorserServer(s, NewEndorserServer(*org, scheme, sk, guard)) })
+	case "orderer":
+		orderer := NewOrderer(scheme, config, demoKey(scheme, "orderer"), OrdererConfig{MaxTransactions: *blockSize, MaxBytes: *blockBytes, BatchTimeout: *blockTimeout})
+		go func() {
+			for range time.Tick(10 * time.Second) {
+				log.Print(orderer.Metrics())
+			}
+		}()
+		if *transport == "tcp" {
+			log.Fatal(ServeWireOrderer(listener(tlsConfig), orderer))
+		}
+		serveGRPC(func(s *grpc.Server) { chiapb.RegisterOrdererServer(s, NewOrdererServer(orderer)) })
+	case "peer":
+		err := DeliverBlocks(context.Background(), scheme, config, dialOrderer(), 0, 0, func(block *Block) {
+			log.Printf("committed block %d with %d transactions", block.Header.Number, len(block.Transactions))
+		})
+		log.Fatal(err)
+	case "client":
+		addrs, err := parseEndorsers(*endorserAddrs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		endorsers := make(map[string]EndorserClient)
+		for name, addr := range addrs {
+			if *transport == "tcp" {
+				conn, err := DialWire(addr, tlsConfig)
+				if err != nil {
+					log.Fatal(err)
+				}
+				endorsers[name] = NewWireEndorserClient(conn)
+				continue
+			}
+			conn, err := dialGRPC(addr, tlsConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			endorsers[name] = NewGRPCEndorserClient(conn)
+		}
+		orderer := dialOrderer()
+		var payments []TimedPayment
+		if *trace != "" {
+			if payments, err = LoadTrace(*trace); err != nil {
+				log.Fatal(err)
+			}
+			payments = SpeedUp(payments, *speedup)
+			*count = len(payments)
+		}
+		start := time.Now()
+		for i := 0; i < *count; i++ {
+			var payload []byte
+			if payments != nil {
+				time.Sleep(time.Until(start.Add(payments[i].At)))
+				payload = payments[i].Payment.Bytes()
+			} else {
+				payload, _ = makeRandomArray(*payloadSize)
+			}
+			proposal := NewProposalEnvelope(config.ChannelID, payload, DefaultProposalTTL).Bytes()
+			if err := EndorseAndBroadcast(context.Background(), scheme, config, endorsers, orderer, proposal); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		log.Printf("submitted %d transactions in %v", *count, time.Since(start))
+	}
+	return true
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// OrdererConfig holds the block cutting parameters. A block is cut as soon as one of the limits is hit.
+type OrdererConfig struct {
+	MaxTransactions int           // Maximum number of transactions per block
+	MaxBytes        int           // Maximum total proposal bytes per block (a larger transaction goes into a block on its own), 0 for no limit
+	BatchTimeout    time.Duration // Maximum time the first queued transaction waits for its block
+}
+
+var DefaultOrdererConfig = OrdererConfig{MaxTransactions: 10, MaxBytes: 1 << 20, BatchTimeout: time.Second}
+
+// OrdererMetrics are counters since the orderer started. They are meant to tune the block cutting parameters:
+// bigger blocks amortise the orderer signature and the pairing in the peers' AggregateVerify over more transactions but
+// make transactions wait longer in the queue.
+type OrdererMetrics struct {
+	TransactionsReceived uint64
+	TransactionsRejected uint64
+	BlocksCut            uint64
+	CutsByCount          uint64
+	CutsByBytes          uint64
+	CutsByTimeout        uint64
+	CutsByConfig         uint64        // Blocks cut to make way for a configuration block, and the configuration blocks
+	BlockTransactions    uint64        // Total transactions in cut blocks
+	BlockBytes           uint64        // Total proposal bytes in cut blocks
+	QueueDelay           time.Duration // Total time transactions waited for their block
+	VerifyTime           time.Duration // Total time spent verifying endorsements
+	SignTime             time.Duration // Total time spent signing and aggregating blocks
+}
+
+func (m OrdererMetrics) MeanTransactionsPerBlock() float64 {
+	if m.BlocksCut == 0 {
+		return 0
+	}
+	return float64(m.BlockTransactions) / float64(m.BlocksCut)
+}
+
+func (m OrdererMetrics) MeanQueueDelay() time.Duration {
+	if m.BlockTransactions == 0 {
+		return 0
+	}
+	return m.QueueDelay / time.Duration(m.BlockTransactions)
+}
+
+func (m OrdererMetrics) String() string {
+	return fmt.Sprintf("%d blocks (%d by count, %d by bytes, %d by timeout, %d by config), %.1f tx/block, %d/%d tx rejected, mean queue delay %v, verify %v, sign %v",
+		m.BlocksCut, m.CutsByCount, m.CutsByBytes, m.CutsByTimeout, m.CutsByConfig, m.MeanTransactionsPerBlock(), m.TransactionsRejected, m.TransactionsReceived, m.MeanQueueDelay(), m.VerifyTime, m.SignTime)
+}
+
+type cutReason int
+
+const (
+	cutByCount cutReason = iota
+	cutByBytes
+	cutByTimeout
+	cutByConfig
+)
+
+// Orderer queues verified transactions and cuts, signs and aggregates blocks according to its OrdererConfig.
+// Endorsements are folded into the block aggregate as they are queued (see SignatureAccumulator).
+// It is shared by every transport.
+type Orderer struct {
+	scheme SignatureScheme
+	config *ChannelConfig
+	sk     *blschia.PrivateKey
+	cuts   OrdererConfig
+	guard  *ReplayGuard // Set when the channel has an ID
+
+	mu         sync.Mutex
+	queue      []*Transaction
+	queued     []time.Time
+	queueBytes int
+	aggregate  SignatureAccumulator // Endorsements of the queued transactions
+	timer      *time.Timer
+	blocks     []*Block
+	update     chan struct{} // Closed and replaced whenever a block is cut
+	metrics    OrdererMetrics
+}
+
+var ErrInvalidEndorsement = errors.New("orderer: endorsement does not satisfy the endorsement policy")
+
+func NewOrderer(scheme SignatureScheme, config *ChannelConfig, sk *blschia.PrivateKey, cuts OrdererConfig) *Orderer {
+	o := &Orderer{scheme: scheme, config: config, sk: sk, cuts: cuts, update: make(chan struct{})}
+	if config.ChannelID != "" {
+		o.guard = NewReplayGuard(config.ChannelID, DefaultReplayCapacity, DefaultProposalTTL)
+	}
+	return o
+}
+
+// Submit verifies the endorsement of tx, rejects replays and queues it for the next block.
+// Configuration transactions are applied instead (see SubmitConfig).
+func (o *Orderer) Submit(tx *Transaction) error {
+	if IsConfigTransaction(tx.Proposal) {
+		return o.SubmitConfig(tx)
+	}
+	o.mu.Lock()
+	config := o.config
+	o.mu.Unlock()
+	start := time.Now()
+	var err error
+	if !config.VerifyEndorsement(o.scheme, tx) {
+		err = ErrInvalidEndorsement
+	} else if o.guard != nil {
+		// Only after verifying so that unendorsed proposals cannot fill the seen set
+		_, err = o.guard.Check(tx.Proposal, time.Now())
+	}
+	verifyTime := time.Since(start)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.metrics.TransactionsReceived++
+	o.metrics.VerifyTime += verifyTime
+	// The configuration changed while verifying
+	if err == nil && o.config != config && !o.config.VerifyEndorsement(o.scheme, tx) {
+		err = ErrInvalidEndorsement
+	}
+	if err == nil {
+		err = o.config.CheckEndorsers(uint64(len(o.blocks)))
+	}
+	if err != nil {
+		o.metrics.TransactionsRejected++
+		return err
+	}
+	size := len(tx.Proposal)
+	if o.cuts.MaxBytes > 0 && len(o.queue) > 0 && o.queueBytes+size > o.cuts.MaxBytes {
+		o.cut(cutByBytes)
+	}
+	o.queue = append(o.queue, tx)
+	o.queued = append(o.queued, time.Now())
+	o.queueBytes += size
+	o.aggregate.Add(tx.Endorsement)
+	switch {
+	case len(o.queue) >= o.cuts.MaxTransactions:
+		o.cut(cutByCount)
+	case o.cuts.MaxBytes > 0 && o.queueBytes >= o.cuts.MaxBytes:
+		o.cut(cutByBytes)
+	case len(o.queue) == 1:
+		o.startTimer()
+	}
+	return nil
+}
+
+// SubmitConfig applies a configuration transaction: the queued transactions are cut into a block, the configuration
+// transaction gets a block of its own and later transactions are verified against the new configuration
+func (o *Orderer) SubmitConfig(tx *Transaction) error {
+	t, err := DecodeConfigTransaction(tx)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.metrics.TransactionsReceived++
+	var next *ChannelConfig
+	if err == nil {
+		start := time.Now()
+		next, err = o.config.ApplyConfig(o.scheme, t)
+		o.metrics.VerifyTime += time.Since(start)
+	}
+	if err == nil {
+		pks, _ := o.config.AdminKeys(t.Signers)
+		// The configuration block comes after the block the queue is cut into
+		height := uint64(len(o.blocks))
+		if len(o.queue) > 0 {
+			height++
+		}
+		err = o.config.CheckRevoked(pks, height)
+	}
+	if err != nil {
+		o.metrics.TransactionsRejected++
+		return err
+	}
+	if len(o.queue) > 0 {
+		o.cut(cutByConfig)
+	}
+	o.queue = []*Transaction{tx}
+	o.queued = []time.Time{time.Now()}
+	o.queueBytes = len(tx.Proposal)
+	o.aggregate.Add(tx.Endorsement)
+	o.cut(cutByConfig)
+	o.config = next
+	return nil
+}
+
+// startTimer must be called with o.mu held
+func (o *Orderer) startTimer() {
+	var timer *time.Timer
+	timer = time.AfterFunc(o.cuts.BatchTimeout, func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		// A block cut in the meantime replaced (or cleared) the timer
+		if o.timer == timer && len(o.queue) > 0 {
+			o.cut(cutByTimeout)
+		}
+	})
+	o.timer = timer
+}
+
+// cut must be called with o.mu held
+func (o *Orderer) cut(reason cutReason) {
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	var previous *BlockHeader
+	if len(o.blocks) > 0 {
+		previous = &o.blocks[len(o.blocks)-1].Header
+	}
+	start := time.Now()
+	block := NewBlock(previous, o.queue)
+	block.SignAccumulated(o.scheme, o.sk, &o.aggregate)
+	o.metrics.SignTime += time.Since(start)
+
+	o.metrics.BlocksCut++
+	switch reason {
+	case cutByCount:
+		o.metrics.CutsByCount++
+	case cutByBytes:
+		o.metrics.CutsByBytes++
+	case cutByTimeout:
+		o.metrics.CutsByTimeout++
+	case cutByConfig:
+		o.metrics.CutsByConfig++
+	}
+	o.metrics.BlockTransactions += uint64(len(o.queue))
+	o.metrics.BlockBytes += uint64(o.queueBytes)
+	for _, queued := range o.queued {
+		o.metrics.QueueDelay += start.Sub(queued)
+	}
+
+	o.queue, o.queued, o.queueBytes = nil, nil, 0
+	o.aggregate.Reset()
+	o.blocks = append(o.blocks, block)
+	close(o.update)
+	o.update = make(chan struct{})
+}
+
+// Evict drops a queued transaction (e.g. one that expired) before its block is cut and reports whether it was queued
+func (o *Orderer) Evict(proposal []byte) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, tx := range o.queue {
+		if !bytes.Equal(tx.Proposal, proposal) {
+			continue
+		}
+		o.aggregate.Remove(tx.Endorsement)
+		o.queueBytes -= len(tx.Proposal)
+		o.queue = append(o.queue[:i], o.queue[i+1:]...)
+		o.queued = append(o.queued[:i], o.queued[i+1:]...)
+		if len(o.queue) == 0 && o.timer != nil {
+			o.timer.Stop()
+			o.timer = nil
+		}
+		return true
+	}
+	return false
+}
+
+// Blocks returns the blocks cut so far from number start onwards and a channel closed when the next block is cut
+func (o *Orderer) Blocks(start uint64) ([]*Block, <-chan struct{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.blocks[min(start, uint64(len(o.blocks))):], o.update
+}
+
+func (o *Orderer) Metrics() OrdererMetrics {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.metrics
+}
+
+// Submitting the same stream of transactions under different block cutting parameters
+func OrdererExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	txs := make([]*Transaction, 24)
+	for i := range txs {
+		proposal, _ := makeRandomArray(5000)
+		txs[i] = exampleEndorse(scheme, config, sks, proposal)
+	}
+
+	for _, cuts := range []OrdererConfig{
+		{MaxTransactions: 2, MaxBytes: 1 << 20, BatchTimeout: 50 * time.Millisecond},
+		{MaxTransactions: 10, MaxBytes: 1 << 20, BatchTimeout: 50 * time.Millisecond},
+		{MaxTransactions: 10, MaxBytes: 16000, BatchTimeout: 50 * time.Millisecond},
+	} {
+		orderer := NewOrderer(scheme, config, orderer_sk, cuts)
+		for _, tx := range txs {
+			if err := orderer.Submit(tx); err != nil {
+				panic(err)
+			}
+		}
+		// Wait for the batch timeout to flush the remaining transactions
+		for {
+			_, update := orderer.Blocks(^uint64(0))
+			if orderer.Metrics().BlockTransactions == uint64(len(txs)) {
+				break
+			}
+			<-update
+		}
+		blocks, _ := orderer.Blocks(0)
+		for _, block := range blocks {
+			if !config.VerifyBlock(scheme, block) {
+				panic("orderer produced an invalid block")
+			}
+		}
+		fmt.Printf("max %d tx, %d bytes: %v\n", cuts.MaxTransactions, cuts.MaxBytes, orderer.Metrics())
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Organisation identities. A local CA issues X.509 certificates to organisations with an extension holding the
+// organisation's BLS public key (the 48 byte G1Element) and its proof of possession, so the certificate binds the
+// organisation name to the key its endorsements are verified with. Verifying an identity checks the certificate chain
+// up to a trusted root, parses the key (rejecting invalid points) and checks the PoP before the key is admitted into a
+// channel configuration. The certificate's own key pair is an ordinary ECDSA key, e.g. for TLS.
+
+// Private enterprise arc for the BLS key extension
+var oidBLSKeyExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 59446, 1, 1}
+
+var (
+	ErrNoBLSKey        = errors.New("pki: certificate has no BLS key extension")
+	ErrIdentityPoP     = errors.New("pki: invalid proof of possession in certificate")
+	ErrIdentityChain   = errors.New("pki: certificate chain does not verify")
+	ErrDuplicateOrg    = errors.New("pki: two certificates for the same organisation")
+	ErrIdentitySubject = errors.New("pki: certificate names no organisation")
+)
+
+// blsKeyExtension is the DER value of the extension
+type blsKeyExtension struct {
+	PublicKey []byte // Serialized G1Element
+	PoP       []byte // Serialized G2Element, a PopSchemeMPL proof of possession
+}
+
+// Identity is an organisation admitted by VerifyIdentity
+type Identity struct {
+	Org         string
+	Key         *blschia.G1Element
+	Certificate *x509.Certificate
+}
+
+type LocalCA struct {
+	Certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+}
+
+// NewLocalCA creates a self-signed root valid for validity
+func NewLocalCA(name string, validity time.Duration) (*LocalCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalCA{Certificate: cert, key: key}, nil
+}
+
+// Issue certifies that org holds the BLS key pk, after checking its proof of possession. certKey is the public key
+// of the certificate itself.
+func (ca *LocalCA) Issue(org string, pk *blschia.G1Element, pop *blschia.G2Element, certKey crypto.PublicKey, validity time.Duration) (*x509.Certificate, error) {
+	if !blschia.NewPopSchemeMPL().PopVerify(pk, pop) {
+		return nil, ErrIdentityPoP
+	}
+	return ca.issue(org, pk, pop, certKey, validity)
+}
+
+func (ca *LocalCA) issue(org string, pk *blschia.G1Element, pop *blschia.G2Element, certKey crypto.PublicKey, validity time.Duration) (*x509.Certificate, error) {
+	value, err := asn1.Marshal(blsKeyExtension{PublicKey: pk.Serialize(), PoP: pop.Serialize()})
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: org, Organization: []string{org}},
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        now.Add(validity),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: []pkix.Extension{{Id: oidBLSKeyExtension, Value: value}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, certKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// VerifyIdentity checks cert against roots (and intermediates, which may be nil) at now and returns the organisation and
+// BLS key it certifies
+func VerifyIdentity(cert *x509.Certificate, roots, intermediates *x509.CertPool, now time.Time) (*Identity, error) {
+	_, err := cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIdentityChain, err)
+	}
+	if len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] == "" {
+		return nil, ErrIdentitySubject
+	}
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(oidBLSKeyExtension) {
+			continue
+		}
+		var value blsKeyExtension
+		if rest, err := asn1.Unmarshal(extension.Value, &value); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("%w: malformed extension", ErrNoBLSKey)
+		}
+		pk, err := ParseBLSBytes[*blschia.G1Element](value.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("pki: invalid BLS key in certificate: %w", err)
+		}
+		pop, err := ParseBLSBytes[*blschia.G2Element](value.PoP)
+		if err != nil || !blschia.NewPopSchemeMPL().PopVerify(pk, pop) {
+			return nil, ErrIdentityPoP
+		}
+		return &Identity{Org: cert.Subject.Organization[0], Key: pk, Certificate: cert}, nil
+	}
+	return nil, ErrNoBLSKey
+}
+
+// ChannelMembers admits every certified organisation, the membership part of a ChannelConfig
+func ChannelMembers(certs []*x509.Certificate, roots *x509.CertPool, now time.Time) (map[string]*blschia.G1Element, error) {
+	members := make(map[string]*blschia.G1Element)
+	for _, cert := range certs {
+		identity, err := VerifyIdentity(cert, roots, nil, now)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cert.Subject.CommonName, err)
+		}
+		if _, found := members[identity.Org]; found {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateOrg, identity.Org)
+		}
+		members[identity.Org] = identity.Key
+	}
+	return members, nil
+}
+
+// A channel whose members come from certificates issued by a local CA. Certificates from another CA, with a proof of
+// possession for another key or past their validity are not admitted.
+func CertificateIdentityExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	ca, err := NewLocalCA("UPI Network CA", 24*time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate)
+
+	sks := make(map[string]*blschia.PrivateKey)
+	var certs []*x509.Certificate
+	for _, org := range exampleOrganisations {
+		sk, pk, pop := newOrgKey(scheme)
+		sks[org] = sk
+		tls_key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		cert, err := ca.Issue(org, pk, pop, tls_key.Public(), time.Hour)
+		if err != nil {
+			panic(err)
+		}
+		// Round trip through PEM as certificates are distributed
+		block, _ := pem.Decode(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+		if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+			panic(err)
+		}
+		certs = append(certs, cert)
+	}
+	members, err := ChannelMembers(certs, roots, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	config := &ChannelConfig{Members: members, Endorsers: exampleOrganisations}
+	tx := exampleEndorse(scheme, config, sks, examplePayment("SBI", "HDFC", 1500_00).Bytes())
+	if !config.VerifyEndorsement(scheme, tx) {
+		panic("failed a verification of an endorsement by certified keys")
+	}
+
+	_, pk, _ := newOrgKey(scheme)
+	_, _, other_pop := newOrgKey(scheme)
+	tls_key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := ca.Issue("ICICI", pk, other_pop, tls_key.Public(), time.Hour); !errors.Is(err, ErrIdentityPoP) {
+		panic("CA issued a certificate without a proof of possession")
+	}
+	// A careless CA that skips the check is caught by the verifier
+	careless, _ := ca.issue("ICICI", pk, other_pop, tls_key.Public(), time.Hour)
+	if _, err := VerifyIdentity(careless, roots, nil, time.Now()); !errors.Is(err, ErrIdentityPoP) {
+		panic("verifier admitted a key without a proof of possession")
+	}
+	rogue, _ := NewLocalCA("Rogue CA", time.Hour)
+	_, pk, pop := newOrgKey(scheme)
+	impostor, _ := rogue.Issue("SBI", pk, pop, tls_key.Public(), time.Hour)
+	if _, err := VerifyIdentity(impostor, roots, nil, time.Now()); !errors.Is(err, ErrIdentityChain) {
+		panic("verifier admitted a certificate from an untrusted CA")
+	}
+	if _, err := VerifyIdentity(certs[0], roots, nil, time.Now().Add(2*time.Hour)); !errors.Is(err, ErrIdentityChain) {
+		panic("verifier admitted an expired certificate")
+	}
+	fmt.Printf("pki: %d organisations admitted from %d byte certificates\n", len(members), len(certs[0].Raw))
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// Raft ordering cluster (crash fault tolerant, like Fabric's etcdraft orderer which runs on the same library).
+// The leader cuts blocks and proposes them unsigned through the raft log. Once a block is committed every consenter
+// checks it, signs the header and sends its signature to the other consenters. A consenter holding signatures from a
+// quorum aggregates them into the orderer signature of the block (see Block.SignQuorum) and appends it to its ledger,
+// so peers pull from any consenter and check the quorum with ChannelConfig.VerifyBlock.
+
+const raftTickInterval = 10 * time.Millisecond
+
+var ErrNoLeader = errors.New("raft: no leader elected")
+
+// RaftCluster runs every consenter of the channel in process, with messages between them passed over channels
+type RaftCluster struct {
+	scheme     SignatureScheme
+	config     *ChannelConfig
+	guard      *ReplayGuard // Set when the channel has an ID
+	consenters []*raftConsenter
+}
+
+type consenterVote struct {
+	from   int
+	number uint64
+	sig    *blschia.G2Element
+}
+
+// pendingBlock is an agreed block waiting for a quorum of header signatures
+type pendingBlock struct {
+	block *Block
+	votes map[int]*blschia.G2Element
+	early []consenterVote // Received before the block was applied, verified once it is
+}
+
+type raftConsenter struct {
+	cluster  *RaftCluster
+	index    int // Into ChannelConfig.Consenters, the raft ID is index+1
+	sk       *blschia.PrivateKey
+	cuts     OrdererConfig
+	node     raft.Node
+	storage  *raft.MemoryStorage
+	messages chan raftpb.Message
+	votes    chan consenterVote
+	submits  chan *Transaction
+	stop     chan struct{}
+	stopped  atomic.Bool
+	leader   atomic.Bool
+	ordered  *ReplayGuard // Nonces of the agreed transactions, set when the channel has an ID
+
+	// Owned by the run goroutine
+	queue    []*Transaction
+	batch    <-chan time.Time
+	applied  *BlockHeader // Last agreed header
+	proposed []byte       // Block proposed as leader, not applied yet
+	pending  map[uint64]*pendingBlock
+
+	mu     sync.Mutex
+	blocks []*Block
+	update chan struct{}
+}
+
+// NewRaftCluster starts a consenter for every key in sks. sks[i] must be the key of config.Consenters[i].
+func NewRaftCluster(scheme SignatureScheme, config *ChannelConfig, sks []*blschia.PrivateKey, cuts OrdererConfig) *RaftCluster {
+	c := &RaftCluster{scheme: scheme, config: config}
+	if config.ChannelID != "" {
+		c.guard = NewReplayGuard(config.ChannelID, DefaultReplayCapacity, DefaultProposalTTL)
+	}
+	peers := make([]raft.Peer, len(sks))
+	for i := range sks {
+		peers[i] = raft.Peer{ID: uint64(i + 1)}
+	}
+	for i, sk := range sks {
+		storage := raft.NewMemoryStorage()
+		node := raft.StartNode(&raft.Config{
+			ID:              uint64(i + 1),
+			ElectionTick:    10,
+			HeartbeatTick:   1,
+			Storage:         storage,
+			MaxSizePerMsg:   1 << 20,
+			MaxInflightMsgs: 256,
+			Logger:          &raft.DefaultLogger{Logger: log.New(io.Discard, "", 0)},
+		}, peers)
+		c.consenters = append(c.consenters, &raftConsenter{
+			cluster:  c,
+			index:    i,
+			sk:       sk,
+			cuts:     cuts,
+			node:     node,
+			storage:  storage,
+			messages: make(chan raftpb.Message, 1024),
+			votes:    make(chan consenterVote, 1024),
+			submits:  make(chan *Transaction, 1024),
+			stop:     make(chan struct{}),
+			pending:  make(map[uint64]*pendingBlock),
+			update:   make(chan struct{}),
+		})
+		if config.ChannelID != "" {
+			c.consenters[i].ordered = NewReplayGuard(config.ChannelID, DefaultReplayCapacity, DefaultProposalTTL)
+		}
+	}
+	for _, consenter := range c.consenters {
+		go consenter.run()
+	}
+	return c
+}
+
+// Leader returns the index of the consenter currently leading, or -1 during an election
+func (c *RaftCluster) Leader() int {
+	for _, consenter := range c.consenters {
+		if !consenter.stopped.Load() && consenter.leader.Load() {
+			return consenter.index
+		}
+	}
+	return -1
+}
+
+// WaitLeader blocks until a leader is elected or ctx is done
+func (c *RaftCluster) WaitLeader(ctx context.Context) (int, error) {
+	for {
+		if leader := c.Leader(); leader >= 0 {
+			return leader, nil
+		}
+		select {
+		case <-ctx.Done():
+			return -1, ErrNoLeader
+		case <-time.After(raftTickInterval):
+		}
+	}
+}
+
+// Submit verifies the endorsement of tx, rejects replays and queues it at the leader for the next block.
+// Transactions queued at a leader that crashes before proposing them are lost and must be resubmitted with a new nonce.
+func (c *RaftCluster) Submit(tx *Transaction) error {
+	if !c.config.VerifyEndorsement(c.scheme, tx) {
+		return ErrInvalidEndorsement
+	}
+	if c.guard != nil {
+		if _, err := c.guard.Check(tx.Proposal, time.Now()); err != nil {
+			return err
+		}
+	}
+	leader := c.Leader()
+	if leader < 0 {
+		return ErrNoLeader
+	}
+	select {
+	case c.consenters[leader].submits <- tx:
+		return nil
+	case <-c.consenters[leader].stop:
+		return ErrNoLeader
+	}
+}
+
+// Blocks returns the blocks in the ledger of consenter i from number start onwards and a channel closed when the next block is appended
+func (c *RaftCluster) Blocks(i int, start uint64) ([]*Block, <-chan struct{}) {
+	consenter := c.consenters[i]
+	consenter.mu.Lock()
+	defer consenter.mu.Unlock()
+	return consenter.blocks[min(start, uint64(len(consenter.blocks))):], consenter.update
+}
+
+// StopConsenter crashes consenter i
+func (c *RaftCluster) StopConsenter(i int) {
+	consenter := c.consenters[i]
+	if consenter.stopped.CompareAndSwap(false, true) {
+		close(consenter.stop)
+		consenter.node.Stop()
+	}
+}
+
+func (c *RaftCluster) Stop() {
+	for i := range c.consenters {
+		c.StopConsenter(i)
+	}
+}
+
+// Messages to a stopped or overloaded consenter are dropped, raft retransmits
+func (c *RaftCluster) sendMessage(m raftpb.Message) {
+	to := c.consenters[m.To-1]
+	if to.stopped.Load() {
+		return
+	}
+	select {
+	case to.messages <- m:
+	default:
+	}
+}
+
+func (c *RaftCluster) broadcastVote(vote consenterVote) {
+	for _, to := range c.consenters {
+		if to.index == vote.from || to.stopped.Load() {
+			continue
+		}
+		select {
+		case to.votes <- vote:
+		default:
+		}
+	}
+}
+
+func (r *raftConsenter) run() {
+	ticker := time.NewTicker(raftTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.node.Tick()
+		case m := <-r.messages:
+			r.node.Step(context.Background(), m)
+		case vote := <-r.votes:
+			r.addVote(vote)
+		case tx := <-r.submits:
+			r.queue = append(r.queue, tx)
+			if len(r.queue) == 1 {
+				r.batch = time.After(r.cuts.BatchTimeout)
+			}
+			r.propose(false)
+		case <-r.batch:
+			r.propose(true)
+		case rd := <-r.node.Ready():
+			r.ready(rd)
+		}
+	}
+}
+
+// ready persists and sends what raft asks for and applies the committed entries
+func (r *raftConsenter) ready(rd raft.Ready) {
+	if rd.SoftState != nil {
+		r.leader.Store(rd.SoftState.RaftState == raft.StateLeader)
+		if !r.leader.Load() {
+			r.queue, r.batch, r.proposed = nil, nil, nil
+		}
+	}
+	if !raft.IsEmptyHardState(rd.HardState) {
+		r.storage.SetHardState(rd.HardState)
+	}
+	r.storage.Append(rd.Entries)
+	for _, m := range rd.Messages {
+		r.cluster.sendMessage(m)
+	}
+	for _, entry := range rd.CommittedEntries {
+		switch entry.Type {
+		case raftpb.EntryConfChange:
+			var cc raftpb.ConfChange
+			cc.Unmarshal(entry.Data)
+			r.node.ApplyConfChange(cc)
+		case raftpb.EntryNormal:
+			if len(entry.Data) > 0 {
+				r.apply(entry.Data)
+			}
+		}
+	}
+	r.node.Advance()
+	r.propose(false)
+}
+
+// propose cuts a block from the queue when this consenter leads, the previous proposal was agreed and a limit was hit
+// (or the batch timeout expired). Proposing one block at a time keeps the leader's chain in line with the agreed one.
+func (r *raftConsenter) propose(timeout bool) {
+	if !r.leader.Load() || r.proposed != nil || len(r.queue) == 0 {
+		return
+	}
+	size := 0
+	count := 0
+	for count < len(r.queue) && count < r.cuts.MaxTransactions && (r.cuts.MaxBytes == 0 || count == 0 || size+len(r.queue[count].Proposal) <= r.cuts.MaxBytes) {
+		size += len(r.queue[count].Proposal)
+		count++
+	}
+	if count == len(r.queue) && count < r.cuts.MaxTransactions && !timeout {
+		return
+	}
+	data := encodeBlockProposal(NewBlock(r.applied, r.queue[:count]))
+	// Propose blocks when this node has just lost leadership, and Ready must keep being drained for a new leader to be
+	// elected. The batch stays queued and is proposed again a tick later, or dropped if the loss of leadership shows.
+	ctx, cancel := context.WithTimeout(context.Background(), raftTickInterval)
+	defer cancel()
+	if err := r.node.Propose(ctx, data); err != nil {
+		r.batch = time.After(raftTickInterval)
+		return
+	}
+	r.proposed = data
+	r.queue = r.queue[count:]
+	r.batch = nil
+	if len(r.queue) > 0 {
+		r.batch = time.After(r.cuts.BatchTimeout)
+	}
+}
+
+// apply checks an agreed block and signs its header. Every consenter applies the same entries in the same order so a
+// stale proposal (e.g. from a new leader that had not applied everything yet) is skipped by all of them.
+func (r *raftConsenter) apply(data []byte) {
+	block, err := decodeBlockProposal(data)
+	if err != nil {
+		return
+	}
+	ours := r.proposed != nil && bytes.Equal(r.proposed, data)
+	if ours {
+		r.proposed = nil
+	}
+	if !validProposal(r.cluster.scheme, r.cluster.config, r.ordered, r.applied, block) {
+		if ours {
+			// A stale proposal, its transactions go in the next one unless they were ordered meanwhile
+			var requeue []*Transaction
+			for _, tx := range block.Transactions {
+				if r.ordered == nil || r.ordered.CheckOrdered([][]byte{tx.Proposal}) == nil {
+					requeue = append(requeue, tx)
+				}
+			}
+			r.queue = append(requeue, r.queue...)
+		}
+		return
+	}
+	number := block.Header.Number
+	r.applied = &block.Header
+	if r.ordered != nil {
+		r.ordered.Ordered(transactionLeaves(block.Transactions))
+	}
+
+	pending := r.pendingBlock(number)
+	pending.block = block
+	vote := consenterVote{from: r.index, number: number, sig: r.cluster.scheme.Sign(r.sk, block.Header.Bytes())}
+	pending.votes[r.index] = vote.sig
+	r.cluster.broadcastVote(vote)
+	for _, early := range pending.early {
+		r.addVote(early)
+	}
+	pending.early = nil
+	r.finalise()
+}
+
+// validProposal checks that an unsigned block extends previous and that its transactions match the header, are endorsed
+// and, when the channel has an ID, were not ordered before (ordered holds the nonces of the agreed blocks)
+func validProposal(scheme SignatureScheme, config *ChannelConfig, ordered *ReplayGuard, previous *BlockHeader, block *Block) bool {
+	var number uint64
+	var previousHash []byte
+	if previous != nil {
+		number = previous.Number + 1
+		previousHash = previous.Hash()
+	}
+	if block.Header.Number != number || !bytes.Equal(block.Header.PreviousHash, previousHash) {
+		return false
+	}
+	leaves := make([][]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		if !config.VerifyEndorsement(scheme, tx) {
+			return false
+		}
+		leaves[i] = tx.Proposal
+	}
+	if ordered != nil && ordered.CheckOrdered(leaves) != nil {
+		return false
+	}
+	return bytes.Equal(block.Header.TransactionsRoot, MerkleRoot(leaves))
+}
+
+func (r *raftConsenter) pendingBlock(number uint64) *pendingBlock {
+	pending, found := r.pending[number]
+	if !found {
+		pending = &pendingBlock{votes: make(map[int]*blschia.G2Element)}
+		r.pending[number] = pending
+	}
+	return pending
+}
+
+// addVote counts a header signature from another consenter once it verifies against the agreed block
+func (r *raftConsenter) addVote(vote consenterVote) {
+	if vote.from < 0 || vote.from >= len(r.cluster.config.Consenters) || vote.number < r.height() {
+		return
+	}
+	pending := r.pendingBlock(vote.number)
+	if pending.block == nil {
+		pending.early = append(pending.early, vote)
+		return
+	}
+	if _, found := pending.votes[vote.from]; found {
+		return
+	}
+	if !r.cluster.scheme.Verify(r.cluster.config.Consenters[vote.from], pending.block.Header.Bytes(), vote.sig) {
+		return
+	}
+	pending.votes[vote.from] = vote.sig
+	r.finalise()
+}
+
+// finalise appends the pending blocks that gathered a quorum, in order
+func (r *raftConsenter) finalise() {
+	for {
+		number := r.height()
+		pending, found := r.pending[number]
+		if !found || pending.block == nil || len(pending.votes) < r.cluster.config.ConsenterQuorum {
+			return
+		}
+		signers := NewSignerBitmap(len(r.cluster.config.Consenters))
+		for signer := range pending.votes {
+			signers.Set(signer)
+		}
+		var sigs []*blschia.G2Element
+		for _, signer := range signers.Indices() {
+			sigs = append(sigs, pending.votes[signer])
+		}
+		pending.block.SignQuorum(r.cluster.scheme, signers, sigs)
+		delete(r.pending, number)
+
+		r.mu.Lock()
+		r.blocks = append(r.blocks, pending.block)
+		close(r.update)
+		r.update = make(chan struct{})
+		r.mu.Unlock()
+	}
+}
+
+// height is the number of blocks in the ledger
+func (r *raftConsenter) height() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return uint64(len(r.blocks))
+}
+
+// encodeBlockProposal serializes an unsigned block for the raft log
+func encodeBlockProposal(block *Block) []byte {
+	fields := make([][]byte, 0, 4+len(block.Transactions))
+	fields = append(fields, binary.BigEndian.AppendUint64(nil, block.Header.Number), block.Header.PreviousHash, block.Header.TransactionsRoot,
+		binary.BigEndian.AppendUint32(nil, block.Header.TransactionCount))
+	for _, tx := range block.Transactions {
+		fields = append(fields, EncodeTransaction(tx))
+	}
+	return EncodeProposal(fields...)
+}
+
+func decodeBlockProposal(data []byte) (*Block, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 4 || len(fields[0]) != 8 || len(fields[3]) != 4 {
+		return nil, ErrMalformedFields
+	}
+	block := &Block{Header: BlockHeader{
+		Number:           binary.BigEndian.Uint64(fields[0]),
+		PreviousHash:     fields[1],
+		TransactionsRoot: fields[2],
+		TransactionCount: binary.BigEndian.Uint32(fields[3]),
+	}}
+	if int(block.Header.TransactionCount) != len(fields)-4 {
+		return nil, ErrMalformedFields
+	}
+	for _, field := range fields[4:] {
+		tx, err := DecodeTransaction(field)
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+	return block, nil
+}
+
+// exampleConsenters replaces the single orderer of config with n consenters
+func exampleConsenters(scheme blschia.Scheme, config *ChannelConfig, n, quorum int) []*blschia.PrivateKey {
+	sks := make([]*blschia.PrivateKey, n)
+	config.Consenters = make([]*blschia.G1Element, n)
+	for i := range sks {
+		seed, _ := makeRandomArray(32)
+		sks[i], _ = scheme.KeyGen(seed)
+		config.Consenters[i], _ = sks[i].G1Element()
+	}
+	config.ConsenterQuorum = quorum
+	return sks
+}
+
+// Five consenters order blocks, one follower and then the leader crash, and a peer checks every block carries a quorum
+func RaftOrderingExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, _ := exampleChannel(scheme)
+	consenter_sks := exampleConsenters(scheme, config, 5, 3)
+	cluster := NewRaftCluster(scheme, config, consenter_sks, OrdererConfig{MaxTransactions: 3, BatchTimeout: 20 * time.Millisecond})
+	defer cluster.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	submitted := 0
+	submit := func(count int) {
+		for i := 0; i < count; i++ {
+			proposal, _ := makeRandomArray(1000)
+			tx := exampleEndorse(scheme, config, sks, proposal)
+			for {
+				if _, err := cluster.WaitLeader(ctx); err != nil {
+					panic(err)
+				}
+				if err := cluster.Submit(tx); err == nil {
+					break
+				}
+			}
+		}
+		submitted += count
+	}
+	// Wait until consenter i has every submitted transaction in its ledger
+	wait := func(i int) []*Block {
+		for {
+			blocks, update := cluster.Blocks(i, 0)
+			committed := 0
+			for _, block := range blocks {
+				committed += len(block.Transactions)
+			}
+			if committed == submitted {
+				return blocks
+			}
+			select {
+			case <-update:
+			case <-ctx.Done():
+				panic(fmt.Sprintf("consenter %d committed %d of %d transactions", i, committed, submitted))
+			}
+		}
+	}
+
+	leader, err := cluster.WaitLeader(ctx)
+	if err != nil {
+		panic(err)
+	}
+	submit(6)
+	wait(leader)
+	follower := (leader + 1) % len(consenter_sks)
+	cluster.StopConsenter(follower)
+	submit(6)
+	wait(leader)
+	cluster.StopConsenter(leader)
+	submit(6)
+	newLeader, _ := cluster.WaitLeader(ctx)
+	blocks := wait(newLeader)
+
+	lightClient := NewLightClient(scheme, config)
+	for _, block := range blocks {
+		if !config.VerifyBlock(scheme, block) {
+			panic("failed a verification of a raft ordered block")
+		}
+		if err := lightClient.AddClusterHeader(block.Header, block.OrdererSignature, block.Signers); err != nil {
+			panic(err)
+		}
+	}
+	// A block signed by fewer consenters than the quorum is rejected
+	last := blocks[len(blocks)-1]
+	forged := *last
+	alone := NewSignerBitmap(len(consenter_sks))
+	alone.Set(newLeader)
+	forged.SignQuorum(scheme, alone, []*blschia.G2Element{scheme.Sign(consenter_sks[newLeader], last.Header.Bytes())})
+	if config.VerifyBlock(scheme, &forged) {
+		panic("accepted a block signed below the quorum")
+	}
+	fmt.Printf("raft: %d blocks with leaders %d then %d, last signed by %v\n", len(blocks), leader, newLeader, last.Signers.Indices())
+}
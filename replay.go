@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Replay protection. On a channel with a ChannelID every proposal is a ProposalEnvelope: the channel's domain separation
+// tag, a random nonce and an expiry in front of the payload. The tag is the first field of every signed message, so an
+// endorsement made on one channel is over a message no verifier of another channel accepts even when the channels share
+// endorser keys. Endorsers and the orderer remember the nonces they have seen in a ReplayGuard until the proposals
+// expire, so an endorsed transaction cannot be resubmitted, and consenters remember those they ordered so that a
+// leader cannot include one again.
+
+const (
+	ProposalNonceSize     = 16
+	DefaultProposalTTL    = 2 * time.Minute
+	DefaultReplayCapacity = 1 << 16
+	channelTagPrefix      = "chia/proposal/v1/"
+)
+
+var (
+	ErrMalformedEnvelope = errors.New("replay: malformed proposal envelope")
+	ErrWrongChannel      = errors.New("replay: proposal is for another channel")
+	ErrProposalExpired   = errors.New("replay: proposal expired")
+	ErrProposalTTL       = errors.New("replay: proposal expires too far in the future")
+	ErrReplay            = errors.New("replay: proposal nonce was already seen")
+)
+
+// ChannelTag is the domain separation tag of a channel
+func ChannelTag(channel string) []byte {
+	return []byte(channelTagPrefix + channel)
+}
+
+type ProposalEnvelope struct {
+	Channel string
+	Nonce   [ProposalNonceSize]byte
+	Expiry  time.Time // Encoded with millisecond precision
+	Payload []byte
+}
+
+// NewProposalEnvelope wraps payload for channel with a fresh nonce, expiring ttl from now
+func NewProposalEnvelope(channel string, payload []byte, ttl time.Duration) *ProposalEnvelope {
+	e := &ProposalEnvelope{Channel: channel, Expiry: time.Now().Add(ttl).UTC().Truncate(time.Millisecond), Payload: payload}
+	nonce, _ := makeRandomArray(ProposalNonceSize)
+	copy(e.Nonce[:], nonce)
+	return e
+}
+
+// Bytes is the proposal endorsers sign
+func (e *ProposalEnvelope) Bytes() []byte {
+	return EncodeProposal(ChannelTag(e.Channel), e.Nonce[:], binary.BigEndian.AppendUint64(nil, uint64(e.Expiry.UnixMilli())), e.Payload)
+}
+
+func DecodeProposalEnvelope(data []byte) (*ProposalEnvelope, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 4 || !bytes.HasPrefix(fields[0], []byte(channelTagPrefix)) || len(fields[1]) != ProposalNonceSize || len(fields[2]) != 8 {
+		return nil, ErrMalformedEnvelope
+	}
+	e := &ProposalEnvelope{
+		Channel: string(fields[0][len(channelTagPrefix):]),
+		Expiry:  time.UnixMilli(int64(binary.BigEndian.Uint64(fields[2]))).UTC(),
+		Payload: fields[3],
+	}
+	copy(e.Nonce[:], fields[1])
+	if !bytes.Equal(e.Bytes(), data) {
+		return nil, ErrMalformedEnvelope
+	}
+	return e, nil
+}
+
+// ownsProposal reports whether proposal is an envelope for this channel. Channels without an ID accept any proposal.
+func (c *ChannelConfig) ownsProposal(proposal []byte) bool {
+	if c.ChannelID == "" {
+		return true
+	}
+	e, err := DecodeProposalEnvelope(proposal)
+	return err == nil && e.Channel == c.ChannelID
+}
+
+type seenNonce struct {
+	nonce  [ProposalNonceSize]byte
+	expiry time.Time
+}
+
+// expiryHeap orders seen nonces by expiry, soonest first
+type expiryHeap []seenNonce
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiry.Before(h[j].expiry) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(seenNonce)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// ReplayGuard is a bounded set of the nonces seen on a channel. Nonces are forgotten once their proposal expires, or
+// earlier when the set is full, in which case every proposal expiring no later than a forgotten one is rejected from
+// then on. Memory stays bounded without ever accepting a replay.
+type ReplayGuard struct {
+	channel  string
+	capacity int
+	maxTTL   time.Duration
+
+	mu       sync.Mutex
+	seen     map[[ProposalNonceSize]byte]struct{}
+	expiries expiryHeap
+	floor    time.Time // Proposals expiring at or before floor are rejected
+}
+
+// NewReplayGuard remembers up to capacity nonces (at least one) of proposals expiring within maxTTL
+func NewReplayGuard(channel string, capacity int, maxTTL time.Duration) *ReplayGuard {
+	capacity = max(capacity, 1)
+	return &ReplayGuard{channel: channel, capacity: capacity, maxTTL: maxTTL, seen: make(map[[ProposalNonceSize]byte]struct{})}
+}
+
+// forget must be called with g.mu held
+func (g *ReplayGuard) forget() {
+	oldest := heap.Pop(&g.expiries).(seenNonce)
+	delete(g.seen, oldest.nonce)
+	if oldest.expiry.After(g.floor) {
+		g.floor = oldest.expiry
+	}
+}
+
+// Check accepts a proposal for the channel that has not expired at now and whose nonce was not seen before, and
+// remembers its nonce
+func (g *ReplayGuard) Check(proposal []byte, now time.Time) (*ProposalEnvelope, error) {
+	e, err := DecodeProposalEnvelope(proposal)
+	if err != nil {
+		return nil, err
+	}
+	if e.Channel != g.channel {
+		return nil, fmt.Errorf("%w: %q instead of %q", ErrWrongChannel, e.Channel, g.channel)
+	}
+	if e.Expiry.After(now.Add(g.maxTTL)) {
+		return nil, ErrProposalTTL
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for len(g.expiries) > 0 && !g.expiries[0].expiry.After(now) {
+		g.forget()
+	}
+	if !e.Expiry.After(now) || !e.Expiry.After(g.floor) {
+		return nil, ErrProposalExpired
+	}
+	if _, found := g.seen[e.Nonce]; found {
+		return nil, ErrReplay
+	}
+	if len(g.expiries) >= g.capacity {
+		g.forget()
+		// The new proposal may be the one now too old to be told apart from a replay
+		if !e.Expiry.After(g.floor) {
+			return nil, ErrProposalExpired
+		}
+	}
+	g.seen[e.Nonce] = struct{}{}
+	heap.Push(&g.expiries, seenNonce{nonce: e.Nonce, expiry: e.Expiry})
+	return e, nil
+}
+
+// CheckOrdered is Check for a consenter validating the transactions of a proposed block, on a guard that only remembers
+// ordered proposals (see Ordered). Every consenter must reach the same verdict so the clock is not read, and a nonce
+// appearing twice in proposals is a replay too. Nothing is remembered.
+func (g *ReplayGuard) CheckOrdered(proposals [][]byte) error {
+	nonces := make(map[[ProposalNonceSize]byte]bool, len(proposals))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, proposal := range proposals {
+		e, err := DecodeProposalEnvelope(proposal)
+		if err != nil {
+			return err
+		}
+		if e.Channel != g.channel {
+			return fmt.Errorf("%w: %q instead of %q", ErrWrongChannel, e.Channel, g.channel)
+		}
+		if !e.Expiry.After(g.floor) {
+			return ErrProposalExpired
+		}
+		if _, found := g.seen[e.Nonce]; found || nonces[e.Nonce] {
+			return ErrReplay
+		}
+		nonces[e.Nonce] = true
+	}
+	return nil
+}
+
+// Ordered remembers the nonces of proposals that passed CheckOrdered once their block is agreed. Nonces are only
+// forgotten when the guard is full, so consenters ordering the same blocks remember the same ones.
+func (g *ReplayGuard) Ordered(proposals [][]byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, proposal := range proposals {
+		e, err := DecodeProposalEnvelope(proposal)
+		if err != nil {
+			continue
+		}
+		if len(g.expiries) >= g.capacity {
+			g.forget()
+		}
+		g.seen[e.Nonce] = struct{}{}
+		heap.Push(&g.expiries, seenNonce{nonce: e.Nonce, expiry: e.Expiry})
+	}
+}
+
+// Resubmitting an endorsed payment, re-endorsing it, letting it expire and moving its endorsement to another channel with
+// the same endorsers all fail
+func ReplayProtectionExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	config.ChannelID = "upi-p2p"
+	other := *config
+	other.ChannelID = "upi-merchant"
+
+	listener, err := ListenWire("127.0.0.1:0", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	go ServeWireEndorser(listener, "NPCI", scheme, sks["NPCI"], NewReplayGuard(config.ChannelID, DefaultReplayCapacity, DefaultProposalTTL))
+	conn, err := DialWire(listener.Addr().String(), nil)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	endorser := NewWireEndorserClient(conn)
+
+	proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", 1500_00).Bytes(), time.Minute).Bytes()
+	if _, err := endorser.Endorse(context.Background(), proposal); err != nil {
+		panic(err)
+	}
+	if _, err := endorser.Endorse(context.Background(), proposal); err == nil {
+		panic("endorser signed a proposal twice")
+	}
+	foreign := NewProposalEnvelope(other.ChannelID, examplePayment("SBI", "HDFC", 1500_00).Bytes(), time.Minute).Bytes()
+	if _, err := endorser.Endorse(context.Background(), foreign); err == nil {
+		panic("endorser signed a proposal for another channel")
+	}
+
+	orderer := NewOrderer(scheme, config, orderer_sk, OrdererConfig{MaxTransactions: 10, BatchTimeout: time.Second})
+	tx := exampleEndorse(scheme, config, sks, proposal)
+	if err := orderer.Submit(tx); err != nil {
+		panic(err)
+	}
+	if err := orderer.Submit(tx); !errors.Is(err, ErrReplay) {
+		panic(fmt.Sprintf("orderer accepted a replayed transaction: %v", err))
+	}
+	expired := NewProposalEnvelope(config.ChannelID, examplePayment("HDFC", "SBI", 420_50).Bytes(), -time.Second).Bytes()
+	if err := orderer.Submit(exampleEndorse(scheme, config, sks, expired)); !errors.Is(err, ErrProposalExpired) {
+		panic(fmt.Sprintf("orderer accepted an expired transaction: %v", err))
+	}
+	// Same endorser keys, so only the tag keeps the endorsement from verifying on the other channel
+	if other.VerifyEndorsement(scheme, tx) {
+		panic("endorsement verified on another channel")
+	}
+	retagged, _ := DecodeProposalEnvelope(proposal)
+	retagged.Channel = other.ChannelID
+	if other.VerifyEndorsement(scheme, &Transaction{Proposal: retagged.Bytes(), Endorsement: tx.Endorsement}) {
+		panic("endorsement verified for a retagged proposal")
+	}
+
+	// A guard with room for two nonces still rejects the replay of one it had to forget
+	guard := NewReplayGuard(config.ChannelID, 2, DefaultProposalTTL)
+	now := time.Now()
+	proposals := make([][]byte, 3)
+	for i := range proposals {
+		proposals[i] = NewProposalEnvelope(config.ChannelID, []byte{byte(i)}, time.Duration(i+1)*time.Second).Bytes()
+		if _, err := guard.Check(proposals[i], now); err != nil {
+			panic(err)
+		}
+	}
+	if _, err := guard.Check(proposals[0], now); err == nil {
+		panic("guard accepted a replay of a forgotten nonce")
+	}
+	fmt.Printf("replay: %d byte envelope, replays, expired and foreign proposals rejected\n", len(proposal))
+}
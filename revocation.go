@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Key revocation. A compromised secret key still produces signatures AggregateVerify accepts, so the channel carries a
+// list of revoked keys (added by ConfigRevokeKey configuration transactions) and verifiers reject any block, header or
+// transaction at or above a revocation's height that a revoked key signed. Blocks below the height stay valid, so
+// historical blocks keep verifying against the configuration that revoked their signers.
+
+var ErrKeyRevoked = errors.New("config: key is revoked")
+
+// Revocation stops Key from verifying in block Height and every later block
+type Revocation struct {
+	Key    *blschia.G1Element
+	Height uint64
+}
+
+// CheckRevoked fails if one of pks is revoked at block height
+func (c *ChannelConfig) CheckRevoked(pks []*blschia.G1Element, height uint64) error {
+	for _, revocation := range c.Revoked {
+		if height < revocation.Height {
+			continue
+		}
+		for _, pk := range pks {
+			if pk.EqualTo(revocation.Key) {
+				return fmt.Errorf("%w from block %d: %x", ErrKeyRevoked, revocation.Height, pk.Serialize()[:8])
+			}
+		}
+	}
+	return nil
+}
+
+// CheckEndorsers fails if a key of the endorsement policy is revoked at block height
+func (c *ChannelConfig) CheckEndorsers(height uint64) error {
+	pks, err := c.EndorserKeys()
+	if err != nil {
+		return err
+	}
+	return c.CheckRevoked(pks, height)
+}
+
+// SBI's key leaks after block 0. The admins revoke it from block 3 on, which a colluding orderer cannot get around, and
+// then rotate it. Blocks signed with the leaked key before block 3 stay valid.
+func RevocationExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	config.ChannelID = "upi-p2p"
+	config.Admins, config.AdminQuorum = exampleOrganisations, 3
+	orderer := NewOrderer(scheme, config, orderer_sk, OrdererConfig{MaxTransactions: 10, BatchTimeout: 20 * time.Millisecond})
+	peer := NewChannelPeer(scheme)
+	if err := peer.Join(config); err != nil {
+		panic(err)
+	}
+	pay := func(sks map[string]*blschia.PrivateKey, config *ChannelConfig) *Transaction {
+		proposal := NewProposalEnvelope(config.ChannelID, examplePayment("SBI", "HDFC", 1500_00).Bytes(), time.Minute).Bytes()
+		return exampleEndorse(scheme, config, sks, proposal)
+	}
+	admins := map[string]*blschia.PrivateKey{"NPCI": sks["NPCI"], "RBI": sks["RBI"], "HDFC": sks["HDFC"]}
+
+	if err := orderer.Submit(pay(sks, config)); err != nil {
+		panic(err)
+	}
+	exampleSync(peer, config.ChannelID, orderer, 0)
+	leaked, _ := sks["SBI"].G1Element()
+	revoke := &ConfigUpdate{Channel: config.ChannelID, Version: 0, Changes: []ConfigChange{{Op: ConfigRevokeKey, Key: leaked, Height: 3}}}
+	if err := orderer.Submit(SignConfigUpdate(scheme, config, revoke, admins).Transaction()); err != nil {
+		panic(err)
+	}
+	exampleSync(peer, config.ChannelID, orderer, 1)
+	v1, _ := peer.Config(config.ChannelID)
+
+	// Block 2 is below the revocation height
+	if err := orderer.Submit(pay(sks, v1)); err != nil {
+		panic(err)
+	}
+	exampleSync(peer, config.ChannelID, orderer, 2)
+	if err := orderer.Submit(pay(sks, v1)); !errors.Is(err, ErrKeyRevoked) {
+		panic(fmt.Sprintf("orderer accepted an endorsement by a revoked key: %v", err))
+	}
+
+	// Block 3 cut by a colluding orderer: the aggregate verifies, the revocation does not let it through
+	blocks, _, _ := peer.Blocks(config.ChannelID, 0)
+	tx := pay(sks, v1)
+	forged := NewBlock(&blocks[2].Header, []*Transaction{tx})
+	forged.Sign(scheme, orderer_sk)
+	ordererPks, _ := v1.OrdererKeys(nil)
+	endorserPks, _ := v1.EndorserKeys()
+	if !forged.Verify(scheme, ordererPks, endorserPks) {
+		panic("forged block does not carry a valid aggregate")
+	}
+	if peer.Commit(config.ChannelID, forged) == nil {
+		panic("peer committed a block endorsed by a revoked key")
+	}
+	client := NewLightClient(scheme, v1)
+	for _, block := range append(blocks, forged) {
+		if err := client.AddHeader(block.Header, block.OrdererSignature); err != nil {
+			panic(err)
+		}
+	}
+	proof, _ := forged.InclusionProof(0)
+	if err := client.VerifyTransaction(3, tx, proof); !errors.Is(err, ErrKeyRevoked) {
+		panic(fmt.Sprintf("light client accepted a transaction endorsed by a revoked key: %v", err))
+	}
+
+	sbi_sk, sbi_pk, sbi_pop := newOrgKey(scheme)
+	rotate := &ConfigUpdate{Channel: config.ChannelID, Version: 1, Changes: []ConfigChange{{Op: ConfigRotateKey, Org: "SBI", Key: sbi_pk, PoP: sbi_pop}}}
+	if err := orderer.Submit(SignConfigUpdate(scheme, v1, rotate, admins).Transaction()); err != nil {
+		panic(err)
+	}
+	exampleSync(peer, config.ChannelID, orderer, 3)
+	v2, _ := peer.Config(config.ChannelID)
+	sks = map[string]*blschia.PrivateKey{"NPCI": sks["NPCI"], "RBI": sks["RBI"], "SBI": sbi_sk, "HDFC": sks["HDFC"]}
+	if err := orderer.Submit(pay(sks, v2)); err != nil {
+		panic(err)
+	}
+	exampleSync(peer, config.ChannelID, orderer, 4)
+
+	blocks, _, _ = peer.Blocks(config.ChannelID, 0)
+	for _, number := range []int{0, 2} {
+		if !v1.VerifyBlock(scheme, blocks[number]) {
+			panic(fmt.Sprintf("historical block %d no longer verifies", number))
+		}
+	}
+	fmt.Printf("revocation: %d blocks, key revoked from block %d, blocks 0 and 2 still verify\n", len(blocks), revoke.Changes[0].Height)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/arun5309/chia/chiapb"
+	"github.com/dashpay/bls-signatures/go-bindings"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC services for the endorser and orderer roles (see chiapb/chia.proto). Clients and peers reach them through the transport interfaces.
+// Signatures travel as serialized G2Elements and are parsed (and so validated) on arrival.
+
+func transactionToProto(tx *Transaction) *chiapb.Transaction {
+	return &chiapb.Transaction{Proposal: tx.Proposal, Endorsement: tx.Endorsement.Serialize()}
+}
+
+func transactionFromProto(tx *chiapb.Transaction) (*Transaction, error) {
+	endorsement, err := ParseBLSBytes[*blschia.G2Element](tx.GetEndorsement())
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{Proposal: tx.GetProposal(), Endorsement: endorsement}, nil
+}
+
+func blockToProto(block *Block) *chiapb.Block {
+	txs := make([]*chiapb.Transaction, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		txs[i] = transactionToProto(tx)
+	}
+	return &chiapb.Block{
+		Header: &chiapb.BlockHeader{
+			Number:           block.Header.Number,
+			PreviousHash:     block.Header.PreviousHash,
+			TransactionsRoot: block.Header.TransactionsRoot,
+			TransactionCount: block.Header.TransactionCount,
+		},
+		Transactions:       txs,
+		OrdererSignature:   block.OrdererSignature.Serialize(),
+		AggregateSignature: block.AggregateSignature.Serialize(),
+		Signers:            block.Signers,
+	}
+}
+
+func blockFromProto(pb *chiapb.Block) (*Block, error) {
+	if pb.GetHeader() == nil {
+		return nil, errors.New("rpc: block without header")
+	}
+	block := &Block{
+		Header: BlockHeader{
+			Number:           pb.Header.GetNumber(),
+			PreviousHash:     pb.Header.GetPreviousHash(),
+			TransactionsRoot: pb.Header.GetTransactionsRoot(),
+			TransactionCount: pb.Header.GetTransactionCount(),
+		},
+		Transactions: make([]*Transaction, len(pb.GetTransactions())),
+		Signers:      pb.GetSigners(),
+	}
+	for i, tx := range pb.GetTransactions() {
+		var err error
+		if block.Transactions[i], err = transactionFromProto(tx); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if block.OrdererSignature, err = ParseBLSBytes[*blschia.G2Element](pb.GetOrdererSignature()); err != nil {
+		return nil, err
+	}
+	if block.AggregateSignature, err = ParseBLSBytes[*blschia.G2Element](pb.GetAggregateSignature()); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+type EndorserServer struct {
+	chiapb.UnimplementedEndorserServer
+	name   string
+	scheme SignatureScheme
+	sk     *blschia.PrivateKey
+	guard  *ReplayGuard
+}
+
+// NewEndorserServer endorses every proposal when guard is nil, otherwise only proposals the guard accepts
+func NewEndorserServer(name string, scheme SignatureScheme, sk *blschia.PrivateKey, guard *ReplayGuard) *EndorserServer {
+	return &EndorserServer{name: name, scheme: scheme, sk: sk, guard: guard}
+}
+
+func (s *EndorserServer) Endorse(ctx context.Context, proposal *chiapb.Proposal) (*chiapb.Endorsement, error) {
+	if s.guard != nil {
+		if _, err := s.guard.Check(proposal.GetPayload(), time.Now()); err != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
+	return &chiapb.Endorsement{Endorser: s.name, Signature: s.scheme.Sign(s.sk, proposal.GetPayload()).Serialize()}, nil
+}
+
+// OrdererServer exposes an Orderer over gRPC
+type OrdererServer struct {
+	chiapb.UnimplementedOrdererServer
+	orderer *Orderer
+}
+
+func NewOrdererServer(orderer *Orderer) *OrdererServer {
+	return &OrdererServer{orderer: orderer}
+}
+
+func (s *OrdererServer) Broadcast(ctx context.Context, pb *chiapb.Transaction) (*chiapb.BroadcastResponse, error) {
+	tx, err := transactionFromProto(pb)
+	if err != nil {
+		return &chiapb.BroadcastResponse{Status: chiapb.BroadcastResponse_BAD_REQUEST, Info: err.Error()}, nil
+	}
+	if err := s.orderer.Submit(tx); errors.Is(err, ErrInvalidEndorsement) {
+		return &chiapb.BroadcastResponse{Status: chiapb.BroadcastResponse_INVALID_ENDORSEMENT, Info: err.Error()}, nil
+	} else if err != nil {
+		return &chiapb.BroadcastResponse{Status: chiapb.BroadcastResponse_BAD_REQUEST, Info: err.Error()}, nil
+	}
+	return &chiapb.BroadcastResponse{Status: chiapb.BroadcastResponse_SUCCESS}, nil
+}
+
+func (s *OrdererServer) Deliver(req *chiapb.DeliverRequest, stream chiapb.Orderer_DeliverServer) error {
+	next := req.GetStart()
+	for {
+		blocks, update := s.orderer.Blocks(next)
+		for _, block := range blocks {
+			if err := stream.Send(blockToProto(block)); err != nil {
+				return err
+			}
+			next++
+		}
+		select {
+		case <-update:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Clients of the gRPC services implementing the EndorserClient and OrdererClient transport interfaces
+
+type grpcEndorserClient struct {
+	client chiapb.EndorserClient
+}
+
+func NewGRPCEndorserClient(conn *grpc.ClientConn) EndorserClient {
+	return &grpcEndorserClient{client: chiapb.NewEndorserClient(conn)}
+}
+
+func (c *grpcEndorserClient) Endorse(ctx context.Context, proposal []byte) (*blschia.G2Element, error) {
+	endorsement, err := c.client.Endorse(ctx, &chiapb.Proposal{Payload: proposal})
+	if err != nil {
+		return nil, err
+	}
+	return ParseBLSBytes[*blschia.G2Element](endorsement.GetSignature())
+}
+
+type grpcOrdererClient struct {
+	client chiapb.OrdererClient
+}
+
+func NewGRPCOrdererClient(conn *grpc.ClientConn) OrdererClient {
+	return &grpcOrdererClient{client: chiapb.NewOrdererClient(conn)}
+}
+
+func (c *grpcOrdererClient) Broadcast(ctx context.Context, tx *Transaction) error {
+	resp, err := c.client.Broadcast(ctx, transactionToProto(tx))
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != chiapb.BroadcastResponse_SUCCESS {
+		return fmt.Errorf("rpc: broadcast rejected: %v %s", resp.GetStatus(), resp.GetInfo())
+	}
+	return nil
+}
+
+type grpcBlockStream struct {
+	stream chiapb.Orderer_DeliverClient
+}
+
+func (c *grpcOrdererClient) Deliver(ctx context.Context, start uint64) (BlockStream, error) {
+	stream, err := c.client.Deliver(ctx, &chiapb.DeliverRequest{Start: start})
+	if err != nil {
+		return nil, err
+	}
+	return &grpcBlockStream{stream: stream}, nil
+}
+
+func (s *grpcBlockStream) Recv() (*Block, error) {
+	pb, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return blockFromProto(pb)
+}
+
+// dialGRPC connects to addr, over TLS when tlsConfig is not nil
+func dialGRPC(addr string, tlsConfig *tls.Config) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+}
+
+// Endorsers, orderer and a peer talking gRPC over loopback sockets
+func GRPCExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+
+	serve := func(register func(*grpc.Server)) (string, *grpc.Server) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		server := grpc.NewServer()
+		register(server)
+		go server.Serve(listener)
+		return listener.Addr().String(), server
+	}
+
+	endorsers := make(map[string]EndorserClient)
+	for _, name := range config.Endorsers {
+		addr, server := serve(func(s *grpc.Server) {
+			chiapb.RegisterEndorserServer(s, NewEndorserServer(name, scheme, sks[name], nil))
+		})
+		defer server.Stop()
+		conn, _ := dialGRPC(addr, nil)
+		defer conn.Close()
+		endorsers[name] = NewGRPCEndorserClient(conn)
+	}
+	addr, server := serve(func(s *grpc.Server) {
+		chiapb.RegisterOrdererServer(s, NewOrdererServer(NewOrderer(scheme, config, orderer_sk, OrdererConfig{MaxTransactions: 2, BatchTimeout: 100 * time.Millisecond})))
+	})
+	defer server.Stop()
+	conn, _ := dialGRPC(addr, nil)
+	defer conn.Close()
+	orderer := NewGRPCOrdererClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 0; i < 4; i++ {
+		proposal, _ := makeRandomArray(5000)
+		if err := EndorseAndBroadcast(ctx, scheme, config, endorsers, orderer, proposal); err != nil {
+			panic(err)
+		}
+	}
+	committed := 0
+	err := DeliverBlocks(ctx, scheme, config, orderer, 0, 2, func(block *Block) { committed += len(block.Transactions) })
+	if err != nil {
+		panic(err)
+	}
+	if committed != 4 {
+		panic("peer did not commit every transaction")
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	mrand "math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Discrete simulation of the endorse-order-commit flow: every organisation runs as a goroutine node and nodes only talk
+// through the simulated network, which delays every message by latency, jitter and serialisation time on the link
+// (bandwidth) and retransmits lost messages after a timeout, so loss shows up as added latency like it would over TCP.
+
+// LinkConfig describes every link of the simulated network
+type LinkConfig struct {
+	Latency           time.Duration
+	Jitter            time.Duration // Uniformly distributed extra delay in [0, Jitter)
+	Bandwidth         int           // Bytes per second, 0 for unlimited
+	Loss              float64       // Probability that a transmission is lost, in [0, 1)
+	RetransmitTimeout time.Duration // Delay added for every lost transmission
+}
+
+type SimMessageKind int
+
+const (
+	SimProposal SimMessageKind = iota
+	SimEndorsement
+	SimTransaction
+	SimBlock
+	SimGossip // Block pushed, or a digest (the number of blocks the sender has) in Payload, between peers
+)
+
+type SimMessage struct {
+	From, To  string
+	Kind      SimMessageKind
+	Payload   []byte             // Proposal (proposal, endorsement and transaction messages)
+	Signature *blschia.G2Element // Endorsement or aggregate endorsement
+	Block     *Block
+}
+
+// Size approximates the number of bytes the message occupies on the wire
+func (m *SimMessage) Size() int {
+	size := 16 + len(m.Payload)
+	if m.Signature != nil {
+		size += 96
+	}
+	if m.Block != nil {
+		size += 8 + 32 + 32 + 4 + 2*96
+		for _, tx := range m.Block.Transactions {
+			size += len(tx.Proposal) + 96
+		}
+	}
+	return size
+}
+
+type SimNetwork struct {
+	link    LinkConfig
+	done    chan struct{}
+	mu      sync.Mutex
+	rng     *mrand.Rand
+	inboxes map[string]chan *SimMessage
+	busy    map[[2]string]time.Time // Time until which a link is busy sending earlier messages
+
+	Messages      int
+	Bytes         int
+	Retransmitted int
+}
+
+func NewSimNetwork(link LinkConfig) *SimNetwork {
+	if link.Loss < 0 || link.Loss >= 1 {
+		// Every transmission would be lost and retransmitted forever
+		panic(fmt.Sprintf("simulator: loss %v outside [0, 1)", link.Loss))
+	}
+	return &SimNetwork{
+		link:    link,
+		done:    make(chan struct{}),
+		rng:     mrand.New(mrand.NewSource(time.Now().UnixNano())),
+		inboxes: make(map[string]chan *SimMessage),
+		busy:    make(map[[2]string]time.Time),
+	}
+}
+
+// AddNode registers a node and returns its inbox
+func (n *SimNetwork) AddNode(name string) <-chan *SimMessage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	inbox := make(chan *SimMessage, 1024)
+	n.inboxes[name] = inbox
+	return inbox
+}
+
+func (n *SimNetwork) Done() <-chan struct{} {
+	return n.done
+}
+
+// Stop shuts down the network; messages still in flight are dropped
+func (n *SimNetwork) Stop() {
+	close(n.done)
+}
+
+func (n *SimNetwork) Send(msg *SimMessage) {
+	size := msg.Size()
+	n.mu.Lock()
+	inbox, found := n.inboxes[msg.To]
+	if !found {
+		n.mu.Unlock()
+		panic(fmt.Sprintf("simulator: unknown node %s", msg.To))
+	}
+	now := time.Now()
+	link := [2]string{msg.From, msg.To}
+	start := now
+	if busy := n.busy[link]; busy.After(start) {
+		start = busy
+	}
+	var transmission time.Duration
+	if n.link.Bandwidth > 0 {
+		transmission = time.Duration(float64(size) / float64(n.link.Bandwidth) * float64(time.Second))
+	}
+	n.busy[link] = start.Add(transmission)
+	delay := start.Sub(now) + transmission + n.link.Latency
+	if n.link.Jitter > 0 {
+		delay += time.Duration(n.rng.Int63n(int64(n.link.Jitter)))
+	}
+	for n.link.Loss > 0 && n.rng.Float64() < n.link.Loss {
+		delay += n.link.RetransmitTimeout
+		n.Retransmitted++
+	}
+	n.Messages++
+	n.Bytes += size
+	n.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		select {
+		case inbox <- msg:
+		case <-n.done:
+		}
+	})
+}
+
+// SimulationConfig describes one simulation run
+type SimulationConfig struct {
+	Scheme       blschia.Scheme
+	Link         LinkConfig
+	Peers        int
+	Transactions int
+	Rate         float64 // Transactions submitted per second
+	PayloadSize  int
+	Payments     []TimedPayment // Replayed at their times instead of Transactions random proposals at Rate
+	Cuts         OrdererConfig
+	Timeout      time.Duration // Give up on transactions not committed by then
+}
+
+type SimulationResult struct {
+	Submitted     int
+	Committed     int
+	Blocks        int
+	Elapsed       time.Duration
+	MeanLatency   time.Duration // From submission by the client until every peer has committed
+	P50Latency    time.Duration
+	P99Latency    time.Duration
+	Throughput    float64 // Committed transactions per second
+	Messages      int
+	Bytes         int
+	Retransmitted int
+}
+
+func (r SimulationResult) String() string {
+	return fmt.Sprintf("committed %d/%d in %d blocks, %.1f tx/s, latency mean %v p50 %v p99 %v, %d messages (%d bytes, %d retransmitted)",
+		r.Committed, r.Submitted, r.Blocks, r.Throughput, r.MeanLatency, r.P50Latency, r.P99Latency, r.Messages, r.Bytes, r.Retransmitted)
+}
+
+// simulationStats tracks when each transaction was submitted and how many peers committed it
+type simulationStats struct {
+	mu        sync.Mutex
+	peers     int
+	remaining int
+	blocks    int
+	submitted map[[32]byte]time.Time
+	commits   map[[32]byte]int
+	latencies []time.Duration
+	finished  chan struct{}
+}
+
+func (s *simulationStats) submit(proposal []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.submitted[sha256.Sum256(proposal)] = time.Now()
+}
+
+func (s *simulationStats) commit(block *Block) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if block.Header.TransactionCount > 0 && s.commits[sha256.Sum256(block.Transactions[0].Proposal)] == 0 {
+		s.blocks++
+	}
+	for _, tx := range block.Transactions {
+		id := sha256.Sum256(tx.Proposal)
+		s.commits[id]++
+		if s.commits[id] == s.peers {
+			s.latencies = append(s.latencies, time.Since(s.submitted[id]))
+			s.remaining--
+			if s.remaining == 0 {
+				close(s.finished)
+			}
+		}
+	}
+}
+
+func simEndorser(network *SimNetwork, name string, inbox <-chan *SimMessage, scheme SignatureScheme, sk *blschia.PrivateKey) {
+	for {
+		select {
+		case msg := <-inbox:
+			if msg.Kind == SimProposal {
+				network.Send(&SimMessage{From: name, To: msg.From, Kind: SimEndorsement, Payload: msg.Payload, Signature: scheme.Sign(sk, msg.Payload)})
+			}
+		case <-network.Done():
+			return
+		}
+	}
+}
+
+func simClient(network *SimNetwork, name string, inbox <-chan *SimMessage, scheme SignatureScheme, config *ChannelConfig, cfg *SimulationConfig, stats *simulationStats) {
+	if cfg.Transactions == 0 {
+		return
+	}
+	// at is when the i-th transaction is submitted
+	at := func(i int) time.Duration {
+		if cfg.Payments != nil {
+			return cfg.Payments[i].At
+		}
+		return time.Duration(float64(i+1) * float64(time.Second) / cfg.Rate)
+	}
+	start := time.Now()
+	timer := time.NewTimer(at(0))
+	defer timer.Stop()
+	submitted := 0
+	// Endorsements received so far, keyed by proposal and then by endorser
+	pending := make(map[string]map[string]*blschia.G2Element)
+	for {
+		select {
+		case <-timer.C:
+			var proposal []byte
+			if cfg.Payments != nil {
+				proposal = cfg.Payments[submitted].Payment.Bytes()
+			} else {
+				proposal, _ = makeRandomArray(cfg.PayloadSize)
+			}
+			pending[string(proposal)] = make(map[string]*blschia.G2Element)
+			stats.submit(proposal)
+			for _, endorser := range config.Endorsers {
+				network.Send(&SimMessage{From: name, To: endorser, Kind: SimProposal, Payload: proposal})
+			}
+			submitted++
+			if submitted < cfg.Transactions {
+				timer.Reset(time.Until(start.Add(at(submitted))))
+			}
+		case msg := <-inbox:
+			endorsements, found := pending[string(msg.Payload)]
+			if msg.Kind != SimEndorsement || !found {
+				continue
+			}
+			endorsements[msg.From] = msg.Signature
+			if len(endorsements) < len(config.Endorsers) {
+				continue
+			}
+			delete(pending, string(msg.Payload))
+			sigs := make([]*blschia.G2Element, len(config.Endorsers))
+			for i, endorser := range config.Endorsers {
+				sigs[i] = endorsements[endorser]
+			}
+			tx := &Transaction{Proposal: msg.Payload, Endorsement: scheme.AggregateSigs(sigs...)}
+			if !config.VerifyEndorsement(scheme, tx) {
+				panic("simulator: client received an invalid endorsement")
+			}
+			network.Send(&SimMessage{From: name, To: "orderer", Kind: SimTransaction, Payload: tx.Proposal, Signature: tx.Endorsement})
+		case <-network.Done():
+			return
+		}
+	}
+}
+
+// simOrderer feeds received transactions to an Orderer and forwards every block it cuts to the peers
+func simOrderer(network *SimNetwork, inbox <-chan *SimMessage, orderer *Orderer, peers []string) {
+	var next uint64
+	for {
+		blocks, update := orderer.Blocks(next)
+		for _, block := range blocks {
+			for _, peer := range peers {
+				network.Send(&SimMessage{From: "orderer", To: peer, Kind: SimBlock, Block: block})
+			}
+			next++
+		}
+		select {
+		case msg := <-inbox:
+			if msg.Kind == SimTransaction {
+				orderer.Submit(&Transaction{Proposal: msg.Payload, Endorsement: msg.Signature})
+			}
+		case <-update:
+		case <-network.Done():
+			return
+		}
+	}
+}
+
+func simPeer(network *SimNetwork, inbox <-chan *SimMessage, scheme SignatureScheme, config *ChannelConfig, stats *simulationStats) {
+	for {
+		select {
+		case msg := <-inbox:
+			if msg.Kind == SimBlock && config.VerifyBlock(scheme, msg.Block) {
+				stats.commit(msg.Block)
+			}
+		case <-network.Done():
+			return
+		}
+	}
+}
+
+// RunSimulation runs one client, an endorser per example organisation, an orderer and cfg.Peers peers until every
+// transaction is committed by every peer (or cfg.Timeout elapses)
+func RunSimulation(cfg SimulationConfig) SimulationResult {
+	if cfg.Payments != nil {
+		cfg.Transactions = len(cfg.Payments)
+	}
+	config, sks, orderer_sk := exampleChannel(cfg.Scheme)
+	network := NewSimNetwork(cfg.Link)
+	stats := &simulationStats{
+		peers:     cfg.Peers,
+		remaining: cfg.Transactions,
+		submitted: make(map[[32]byte]time.Time),
+		commits:   make(map[[32]byte]int),
+		finished:  make(chan struct{}),
+	}
+	if cfg.Transactions == 0 {
+		// Nothing to submit, e.g. an empty trace
+		close(stats.finished)
+	}
+
+	for _, name := range config.Endorsers {
+		go simEndorser(network, name, network.AddNode(name), cfg.Scheme, sks[name])
+	}
+	peers := make([]string, cfg.Peers)
+	for i := range peers {
+		peers[i] = fmt.Sprintf("peer%d", i)
+		go simPeer(network, network.AddNode(peers[i]), cfg.Scheme, config, stats)
+	}
+	orderer := NewOrderer(cfg.Scheme, config, orderer_sk, cfg.Cuts)
+	go simOrderer(network, network.AddNode("orderer"), orderer, peers)
+	start := time.Now()
+	go simClient(network, "client", network.AddNode("client"), cfg.Scheme, config, &cfg, stats)
+
+	select {
+	case <-stats.finished:
+	case <-time.After(cfg.Timeout):
+	}
+	elapsed := time.Since(start)
+	network.Stop()
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	result := SimulationResult{
+		Submitted:     len(stats.submitted),
+		Committed:     len(stats.latencies),
+		Blocks:        stats.blocks,
+		Elapsed:       elapsed,
+		Messages:      network.Messages,
+		Bytes:         network.Bytes,
+		Retransmitted: network.Retransmitted,
+	}
+	if result.Committed > 0 {
+		latencies := append([]time.Duration{}, stats.latencies...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var total time.Duration
+		for _, latency := range latencies {
+			total += latency
+		}
+		result.MeanLatency = total / time.Duration(len(latencies))
+		result.P50Latency = latencies[len(latencies)/2]
+		result.P99Latency = latencies[len(latencies)*99/100]
+		result.Throughput = float64(result.Committed) / elapsed.Seconds()
+	}
+	return result
+}
+
+// Same workload over a LAN-like network with some packet loss for each signature scheme
+func NetworkSimulationExample() {
+	link := LinkConfig{Latency: 2 * time.Millisecond, Jitter: time.Millisecond, Bandwidth: 100 << 20, Loss: 0.01, RetransmitTimeout: 20 * time.Millisecond}
+	// The basic scheme is left out as it cannot aggregate several endorsements of the same proposal
+	schemes := []blschia.Scheme{blschia.NewAugSchemeMPL(), blschia.NewPopSchemeMPL()}
+	for _, scheme := range schemes {
+		result := RunSimulation(SimulationConfig{
+			Scheme:       scheme,
+			Link:         link,
+			Peers:        3,
+			Transactions: 20,
+			Rate:         500,
+			PayloadSize:  5000,
+			Cuts:         OrdererConfig{MaxTransactions: 4, BatchTimeout: 10 * time.Millisecond},
+			Timeout:      10 * time.Second,
+		})
+		fmt.Printf("%T: %v\n", scheme, result)
+		if result.Committed != result.Submitted {
+			panic("simulation did not commit every transaction")
+		}
+	}
+}
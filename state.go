@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// World state with MVCC validation, as in Fabric's execute-order-validate flow. Endorsers simulate a transaction against
+// their copy of the state, recording the version of every key read and the value of every key written, and sign the
+// request together with that read/write set. At commit, peers apply the transactions of a block in order and mark a
+// transaction invalid when a key it read has changed since (a conflicting transaction was committed first). The
+// block signature covers invalid transactions too: they stay in the block, they just do not touch the state.
+
+// Version is the height of the transaction that last wrote a key: the block number and 1 + the index of the
+// transaction in the block. {0, 0} is the initial state.
+type Version struct {
+	Block uint64
+	Tx    uint32
+}
+
+type KVRead struct {
+	Key     string
+	Version *Version // nil when the key did not exist
+}
+
+type KVWrite struct {
+	Key   string
+	Value []byte
+}
+
+// ReadWriteSet has its reads and writes sorted by key so that every endorser produces the same bytes
+type ReadWriteSet struct {
+	Reads  []KVRead
+	Writes []KVWrite
+}
+
+var (
+	ErrStateOutOfOrder    = errors.New("state: block committed out of order")
+	ErrInsufficientFunds  = errors.New("state: insufficient funds")
+	ErrMalformedTransfer  = errors.New("state: malformed transfer")
+	ErrMalformedVersion   = errors.New("state: malformed version")
+	ErrMalformedReadWrite = errors.New("state: malformed read/write set")
+)
+
+func (v *Version) bytes() []byte {
+	if v == nil {
+		return nil
+	}
+	return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint64(nil, v.Block), v.Tx)
+}
+
+func decodeVersion(data []byte) (*Version, error) {
+	switch len(data) {
+	case 0:
+		return nil, nil
+	case 12:
+		return &Version{Block: binary.BigEndian.Uint64(data), Tx: binary.BigEndian.Uint32(data[8:])}, nil
+	}
+	return nil, ErrMalformedVersion
+}
+
+func (rw *ReadWriteSet) Bytes() []byte {
+	reads := make([][]byte, 0, 2*len(rw.Reads))
+	for _, read := range rw.Reads {
+		reads = append(reads, []byte(read.Key), read.Version.bytes())
+	}
+	writes := make([][]byte, 0, 2*len(rw.Writes))
+	for _, write := range rw.Writes {
+		writes = append(writes, []byte(write.Key), write.Value)
+	}
+	return EncodeProposal([]byte("rwset"), EncodeProposal(reads...), EncodeProposal(writes...))
+}
+
+func DecodeReadWriteSet(data []byte) (*ReadWriteSet, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 3 || string(fields[0]) != "rwset" {
+		return nil, ErrMalformedReadWrite
+	}
+	reads, err := DecodeFields(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	writes, err := DecodeFields(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	if len(reads)%2 != 0 || len(writes)%2 != 0 {
+		return nil, ErrMalformedReadWrite
+	}
+	rw := &ReadWriteSet{}
+	for i := 0; i < len(reads); i += 2 {
+		version, err := decodeVersion(reads[i+1])
+		if err != nil {
+			return nil, err
+		}
+		rw.Reads = append(rw.Reads, KVRead{Key: string(reads[i]), Version: version})
+	}
+	for i := 0; i < len(writes); i += 2 {
+		rw.Writes = append(rw.Writes, KVWrite{Key: string(writes[i]), Value: writes[i+1]})
+	}
+	return rw, nil
+}
+
+// EncodeEndorsedPayload is what endorsers sign and what goes into Transaction.Proposal: the request and its simulated effects
+func EncodeEndorsedPayload(request []byte, rw *ReadWriteSet) []byte {
+	return EncodeProposal([]byte("endorsed-payload"), request, rw.Bytes())
+}
+
+func DecodeEndorsedPayload(data []byte) ([]byte, *ReadWriteSet, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(fields) != 3 || string(fields[0]) != "endorsed-payload" {
+		return nil, nil, ErrMalformedReadWrite
+	}
+	rw, err := DecodeReadWriteSet(fields[2])
+	if err != nil {
+		return nil, nil, err
+	}
+	return fields[1], rw, nil
+}
+
+type versionedValue struct {
+	value   []byte
+	version Version
+}
+
+// WorldState is the committed key-value state of a channel. It is safe for concurrent use.
+type WorldState struct {
+	mu     sync.RWMutex
+	values map[string]versionedValue
+	height uint64 // Number of blocks committed
+}
+
+func NewWorldState(initial map[string][]byte) *WorldState {
+	s := &WorldState{values: make(map[string]versionedValue)}
+	for key, value := range initial {
+		s.values[key] = versionedValue{value: value}
+	}
+	return s
+}
+
+// Get returns the value of key and its version (nil when the key does not exist)
+func (s *WorldState) Get(key string) ([]byte, *Version) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, found := s.values[key]
+	if !found {
+		return nil, nil
+	}
+	version := v.version
+	return v.value, &version
+}
+
+func (s *WorldState) Height() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.height
+}
+
+// TxSimulator records what a transaction reads from and writes to the state without changing it
+type TxSimulator struct {
+	state  *WorldState
+	reads  map[string]*Version
+	writes map[string][]byte
+}
+
+func (s *WorldState) NewTxSimulator() *TxSimulator {
+	return &TxSimulator{state: s, reads: make(map[string]*Version), writes: make(map[string][]byte)}
+}
+
+// GetState reads a key, seeing the transaction's own writes
+func (sim *TxSimulator) GetState(key string) []byte {
+	if value, found := sim.writes[key]; found {
+		return value
+	}
+	value, version := sim.state.Get(key)
+	if _, found := sim.reads[key]; !found {
+		sim.reads[key] = version
+	}
+	return value
+}
+
+func (sim *TxSimulator) PutState(key string, value []byte) {
+	sim.writes[key] = value
+}
+
+func (sim *TxSimulator) ReadWriteSet() *ReadWriteSet {
+	rw := &ReadWriteSet{}
+	for key, version := range sim.reads {
+		rw.Reads = append(rw.Reads, KVRead{Key: key, Version: version})
+	}
+	for key, value := range sim.writes {
+		rw.Writes = append(rw.Writes, KVWrite{Key: key, Value: value})
+	}
+	sort.Slice(rw.Reads, func(i, j int) bool { return rw.Reads[i].Key < rw.Reads[j].Key })
+	sort.Slice(rw.Writes, func(i, j int) bool { return rw.Writes[i].Key < rw.Writes[j].Key })
+	return rw
+}
+
+type TxValidationCode byte
+
+const (
+	TxValid TxValidationCode = iota
+	TxMVCCConflict
+	TxBadPayload
+)
+
+func (c TxValidationCode) String() string {
+	switch c {
+	case TxValid:
+		return "VALID"
+	case TxMVCCConflict:
+		return "MVCC_READ_CONFLICT"
+	case TxBadPayload:
+		return "BAD_PAYLOAD"
+	}
+	return fmt.Sprintf("TxValidationCode(%d)", byte(c))
+}
+
+// Commit applies the valid transactions of the next block and returns the validation code of every transaction.
+// The block signature must have been verified already.
+func (s *WorldState) Commit(block *Block) ([]TxValidationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if block.Header.Number != s.height {
+		return nil, ErrStateOutOfOrder
+	}
+	codes := make([]TxValidationCode, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		_, rw, err := DecodeEndorsedPayload(tx.Proposal)
+		if err != nil {
+			codes[i] = TxBadPayload
+			continue
+		}
+		if !s.readsCurrent(rw) {
+			codes[i] = TxMVCCConflict
+			continue
+		}
+		version := Version{Block: block.Header.Number, Tx: uint32(i + 1)}
+		for _, write := range rw.Writes {
+			s.values[write.Key] = versionedValue{value: write.Value, version: version}
+		}
+	}
+	s.height++
+	return codes, nil
+}
+
+// readsCurrent must be called with s.mu held
+func (s *WorldState) readsCurrent(rw *ReadWriteSet) bool {
+	for _, read := range rw.Reads {
+		current, found := s.values[read.Key]
+		if read.Version == nil {
+			if found {
+				return false
+			}
+			continue
+		}
+		if !found || current.version != *read.Version {
+			return false
+		}
+	}
+	return true
+}
+
+// Transfer moves Amount paise from the payer's account to the payee's
+type Transfer struct {
+	Payer  string
+	Payee  string
+	Amount uint64
+}
+
+func (t *Transfer) Bytes() []byte {
+	return EncodeProposal([]byte("transfer"), []byte(t.Payer), []byte(t.Payee), binary.BigEndian.AppendUint64(nil, t.Amount))
+}
+
+func balanceKey(account string) string {
+	return "balance/" + account
+}
+
+func encodeBalance(paise uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, paise)
+}
+
+func decodeBalance(value []byte) uint64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+// SimulateTransfer debits the payer and credits the payee in a simulation of the transfer
+func SimulateTransfer(state *WorldState, t *Transfer) (*ReadWriteSet, error) {
+	if t.Payer == t.Payee || t.Amount == 0 {
+		return nil, ErrMalformedTransfer
+	}
+	sim := state.NewTxSimulator()
+	payer := decodeBalance(sim.GetState(balanceKey(t.Payer)))
+	payee := decodeBalance(sim.GetState(balanceKey(t.Payee)))
+	if payer < t.Amount {
+		return nil, ErrInsufficientFunds
+	}
+	sim.PutState(balanceKey(t.Payer), encodeBalance(payer-t.Amount))
+	sim.PutState(balanceKey(t.Payee), encodeBalance(payee+t.Amount))
+	return sim.ReadWriteSet(), nil
+}
+
+// Two transfers from the same account are endorsed against the same state and ordered into one block:
+// the peer accepts the block signature but only the first transfer changes the balances
+func WorldStateExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	alice, alice_ifsc := bankAccount(exampleBanks[0], 1)
+	bob, bob_ifsc := bankAccount(exampleBanks[1], 2)
+	carol, carol_ifsc := bankAccount(exampleBanks[1], 3)
+	initial := map[string][]byte{
+		balanceKey(alice): encodeBalance(100000),
+		balanceKey(bob):   encodeBalance(50000),
+		balanceKey(carol): encodeBalance(0),
+	}
+	const policy = "upi-p2p/all-of:NPCI,RBI,SBI,HDFC"
+	// Every endorser and the peer hold a copy of the state
+	endorserStates := make(map[string]*WorldState)
+	for _, name := range config.Endorsers {
+		endorserStates[name] = NewWorldState(initial)
+	}
+	peerState := NewWorldState(initial)
+
+	endorse := func(p *UPIPayment) *Transaction {
+		var payload []byte
+		for _, name := range config.Endorsers {
+			endorsed, err := SimulatePayment(endorserStates[name], p)
+			if err != nil {
+				panic(err)
+			}
+			if payload != nil && string(payload) != string(endorsed) {
+				panic("endorsers simulated different read/write sets")
+			}
+			payload = endorsed
+		}
+		return exampleEndorse(scheme, config, sks, payload)
+	}
+	txs := []*Transaction{
+		endorse(NewUPIPayment(alice, alice_ifsc, bob, bob_ifsc, 60000, policy)),
+		endorse(NewUPIPayment(alice, alice_ifsc, carol, carol_ifsc, 60000, policy)),
+		endorse(NewUPIPayment(bob, bob_ifsc, carol, carol_ifsc, 10000, policy)),
+	}
+	block := NewBlock(nil, txs)
+	block.Sign(scheme, orderer_sk)
+
+	if !config.VerifyBlock(scheme, block) {
+		panic("failed a verification of the block signature")
+	}
+	codes, err := peerState.Commit(block)
+	if err != nil {
+		panic(err)
+	}
+	if codes[0] != TxValid || codes[1] != TxMVCCConflict || codes[2] != TxMVCCConflict {
+		panic(fmt.Sprintf("unexpected validation codes %v", codes))
+	}
+	for _, state := range endorserStates {
+		state.Commit(block)
+	}
+	alice_balance, _ := peerState.Get(balanceKey(alice))
+	bob_balance, _ := peerState.Get(balanceKey(bob))
+	if decodeBalance(alice_balance) != 40000 || decodeBalance(bob_balance) != 110000 {
+		panic("balances do not reflect the valid transfer only")
+	}
+
+	// Simulated again against the committed state, the third transfer goes through
+	next := NewBlock(&block.Header, []*Transaction{endorse(NewUPIPayment(bob, bob_ifsc, carol, carol_ifsc, 10000, policy))})
+	next.Sign(scheme, orderer_sk)
+	if codes, err := peerState.Commit(next); err != nil || codes[0] != TxValid {
+		panic("resubmitted transfer was not committed")
+	}
+	fmt.Printf("world state: block 0 %v, block 1 valid\n", codes)
+}
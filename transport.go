@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Client side of a transport (gRPC in rpc.go, framed TCP in wire.go)
+
+type EndorserClient interface {
+	Endorse(ctx context.Context, proposal []byte) (*blschia.G2Element, error)
+}
+
+type OrdererClient interface {
+	Broadcast(ctx context.Context, tx *Transaction) error
+	Deliver(ctx context.Context, start uint64) (BlockStream, error)
+}
+
+type BlockStream interface {
+	Recv() (*Block, error)
+}
+
+// EndorseAndBroadcast is the client side: collect an endorsement from every endorser in the policy, aggregate and verify them
+// and broadcast the transaction to the orderer
+func EndorseAndBroadcast(ctx context.Context, scheme SignatureScheme, config *ChannelConfig, endorsers map[string]EndorserClient, orderer OrdererClient, proposal []byte) error {
+	sigs := make([]*blschia.G2Element, len(config.Endorsers))
+	for i, name := range config.Endorsers {
+		endorser, found := endorsers[name]
+		if !found {
+			return fmt.Errorf("transport: no connection to endorser %s", name)
+		}
+		var err error
+		if sigs[i], err = endorser.Endorse(ctx, proposal); err != nil {
+			return err
+		}
+	}
+	tx := &Transaction{Proposal: proposal, Endorsement: scheme.AggregateSigs(sigs...)}
+	if !config.VerifyEndorsement(scheme, tx) {
+		// Cold path: find out which endorser misbehaved
+		for i, name := range config.Endorsers {
+			if !scheme.Verify(config.Members[name], proposal, sigs[i]) {
+				return fmt.Errorf("transport: %s endorsement failed", name)
+			}
+		}
+		return errors.New("transport: aggregate endorsement failed")
+	}
+	return orderer.Broadcast(ctx, tx)
+}
+
+// DeliverBlocks is the peer side: verify and commit every block from start onwards until ctx is done or count blocks were committed (count 0 for no limit)
+func DeliverBlocks(ctx context.Context, scheme SignatureScheme, config *ChannelConfig, orderer OrdererClient, start uint64, count int, commit func(*Block)) error {
+	stream, err := orderer.Deliver(ctx, start)
+	if err != nil {
+		return err
+	}
+	for committed := 0; count == 0 || committed < count; committed++ {
+		block, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if !config.VerifyBlock(scheme, block) {
+			return fmt.Errorf("transport: block %d failed verification", block.Header.Number)
+		}
+		commit(block)
+	}
+	return nil
+}
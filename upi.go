@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// UPI payment transactions. The canonical encoding is what endorsers sign: EncodeProposal over a type tag, the encoding
+// version and every field in a fixed order with integers as fixed size big-endian values. Decoding only accepts the
+// canonical encoding of a valid payment, so a payment has exactly one signable form.
+
+const (
+	UPIPaymentTag     = "upi-payment"
+	UPIPaymentVersion = 1
+	MaxUPIAmount      = 100000_00 // Paise, the per transaction limit for person to person payments
+	upiNonceSize      = 16
+	upiPaymentFields  = 11
+)
+
+var (
+	vpaPattern         = regexp.MustCompile(`^[a-zA-Z0-9._-]{2,256}@[a-zA-Z]{2,64}$`)
+	ifscPattern        = regexp.MustCompile(`^[A-Z]{4}0[A-Z0-9]{6}$`)
+	referencePattern   = regexp.MustCompile(`^[0-9]{12}$`)
+	ErrInvalidPayment  = errors.New("upi: invalid payment")
+	ErrNonCanonicalUPI = errors.New("upi: payment is not in canonical encoding")
+)
+
+type UPIPayment struct {
+	PayerVPA    string // Virtual payment address, e.g. alice@sbi
+	PayerIFSC   string // Branch of the payer's account
+	PayeeVPA    string
+	PayeeIFSC   string
+	Amount      uint64    // Paise
+	ReferenceID string    // 12 digit retrieval reference number
+	Timestamp   time.Time // Encoded with millisecond precision
+	Nonce       [upiNonceSize]byte
+	Policy      string // Endorsement policy the payment is endorsed under
+}
+
+func (p *UPIPayment) Validate() error {
+	switch {
+	case !vpaPattern.MatchString(p.PayerVPA):
+		return fmt.Errorf("%w: payer VPA %q", ErrInvalidPayment, p.PayerVPA)
+	case !vpaPattern.MatchString(p.PayeeVPA):
+		return fmt.Errorf("%w: payee VPA %q", ErrInvalidPayment, p.PayeeVPA)
+	case p.PayerVPA == p.PayeeVPA:
+		return fmt.Errorf("%w: payer and payee are the same", ErrInvalidPayment)
+	case !ifscPattern.MatchString(p.PayerIFSC):
+		return fmt.Errorf("%w: payer IFSC %q", ErrInvalidPayment, p.PayerIFSC)
+	case !ifscPattern.MatchString(p.PayeeIFSC):
+		return fmt.Errorf("%w: payee IFSC %q", ErrInvalidPayment, p.PayeeIFSC)
+	case p.Amount == 0 || p.Amount > MaxUPIAmount:
+		return fmt.Errorf("%w: amount %d paise", ErrInvalidPayment, p.Amount)
+	case !referencePattern.MatchString(p.ReferenceID):
+		return fmt.Errorf("%w: reference ID %q", ErrInvalidPayment, p.ReferenceID)
+	case p.Timestamp.UnixMilli() <= 0:
+		return fmt.Errorf("%w: timestamp %v", ErrInvalidPayment, p.Timestamp)
+	case p.Policy == "":
+		return fmt.Errorf("%w: no endorsement policy", ErrInvalidPayment)
+	}
+	return nil
+}
+
+// Bytes is the canonical encoding of the payment
+func (p *UPIPayment) Bytes() []byte {
+	return EncodeProposal(
+		[]byte(UPIPaymentTag),
+		[]byte{UPIPaymentVersion},
+		[]byte(p.PayerVPA),
+		[]byte(p.PayerIFSC),
+		[]byte(p.PayeeVPA),
+		[]byte(p.PayeeIFSC),
+		binary.BigEndian.AppendUint64(nil, p.Amount),
+		[]byte(p.ReferenceID),
+		binary.BigEndian.AppendUint64(nil, uint64(p.Timestamp.UnixMilli())),
+		p.Nonce[:],
+		[]byte(p.Policy),
+	)
+}
+
+func DecodeUPIPayment(data []byte) (*UPIPayment, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != upiPaymentFields || string(fields[0]) != UPIPaymentTag || len(fields[1]) != 1 || len(fields[6]) != 8 || len(fields[8]) != 8 || len(fields[9]) != upiNonceSize {
+		return nil, ErrNonCanonicalUPI
+	}
+	if fields[1][0] != UPIPaymentVersion {
+		return nil, fmt.Errorf("upi: unsupported payment version %d", fields[1][0])
+	}
+	p := &UPIPayment{
+		PayerVPA:    string(fields[2]),
+		PayerIFSC:   string(fields[3]),
+		PayeeVPA:    string(fields[4]),
+		PayeeIFSC:   string(fields[5]),
+		Amount:      binary.BigEndian.Uint64(fields[6]),
+		ReferenceID: string(fields[7]),
+		Timestamp:   time.UnixMilli(int64(binary.BigEndian.Uint64(fields[8]))).UTC(),
+		Policy:      string(fields[10]),
+	}
+	copy(p.Nonce[:], fields[9])
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(p.Bytes(), data) {
+		return nil, ErrNonCanonicalUPI
+	}
+	return p, nil
+}
+
+func (p *UPIPayment) String() string {
+	return fmt.Sprintf("%s (%s) -> %s (%s) ₹%d.%02d ref %s", p.PayerVPA, p.PayerIFSC, p.PayeeVPA, p.PayeeIFSC, p.Amount/100, p.Amount%100, p.ReferenceID)
+}
+
+// Transfer is the effect of the payment on the world state (see SimulateTransfer)
+func (p *UPIPayment) Transfer() *Transfer {
+	return &Transfer{Payer: p.PayerVPA, Payee: p.PayeeVPA, Amount: p.Amount}
+}
+
+// SimulatePayment returns the endorsed payload of the payment simulated against state
+func SimulatePayment(state *WorldState, p *UPIPayment) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	rw, err := SimulateTransfer(state, p.Transfer())
+	if err != nil {
+		return nil, err
+	}
+	return EncodeEndorsedPayload(p.Bytes(), rw), nil
+}
+
+// UPIBank is a participating bank: its VPA handle and the prefix of its IFSCs
+type UPIBank struct {
+	Name   string
+	Handle string
+	IFSC   string
+}
+
+var exampleBanks = []UPIBank{
+	{Name: "SBI", Handle: "sbi", IFSC: "SBIN"},
+	{Name: "HDFC", Handle: "hdfcbank", IFSC: "HDFC"},
+	{Name: "ICICI", Handle: "icici", IFSC: "ICIC"},
+	{Name: "Axis", Handle: "axisbank", IFSC: "UTIB"},
+	{Name: "PNB", Handle: "pnb", IFSC: "PUNB"},
+}
+
+// bankAccount returns the VPA and IFSC of account number n at bank
+func bankAccount(bank UPIBank, n int) (string, string) {
+	return fmt.Sprintf("user%d@%s", n, bank.Handle), fmt.Sprintf("%s0%06d", bank.IFSC, n%1000000)
+}
+
+// NewUPIPayment fills in a fresh reference ID, timestamp and nonce
+func NewUPIPayment(payerVPA, payerIFSC, payeeVPA, payeeIFSC string, amount uint64, policy string) *UPIPayment {
+	p := &UPIPayment{PayerVPA: payerVPA, PayerIFSC: payerIFSC, PayeeVPA: payeeVPA, PayeeIFSC: payeeIFSC, Amount: amount, Policy: policy,
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond)}
+	reference, _ := rand.Int(rand.Reader, big.NewInt(1e12))
+	p.ReferenceID = fmt.Sprintf("%012d", reference)
+	rand.Read(p.Nonce[:])
+	return p
+}
+
+// examplePayment is a payment between the first accounts of two example banks
+func examplePayment(payer, payee string, amount uint64) *UPIPayment {
+	var from, to UPIBank
+	for _, bank := range exampleBanks {
+		if bank.Name == payer {
+			from = bank
+		}
+		if bank.Name == payee {
+			to = bank
+		}
+	}
+	payerVPA, payerIFSC := bankAccount(from, 1)
+	payeeVPA, payeeIFSC := bankAccount(to, 2)
+	return NewUPIPayment(payerVPA, payerIFSC, payeeVPA, payeeIFSC, amount, "upi-p2p/all-of:NPCI,RBI,SBI,HDFC")
+}
+
+// Endorsing a typed payment, round tripping its canonical encoding and rejecting a tampered amount
+func UPIPaymentExample() {
+	scheme := blschia.NewAugSchemeMPL()
+	config, sks, _ := exampleChannel(scheme)
+
+	payment := examplePayment("SBI", "HDFC", 2500_00)
+	encoded := payment.Bytes()
+	decoded, err := DecodeUPIPayment(encoded)
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(decoded.Bytes(), encoded) {
+		panic("payment encoding does not round trip")
+	}
+	tx := exampleEndorse(scheme, config, sks, encoded)
+	if !config.VerifyEndorsement(scheme, tx) {
+		panic("failed a verification of the payment endorsement")
+	}
+
+	tampered := *decoded
+	tampered.Amount = 25000_00
+	if config.VerifyEndorsement(scheme, &Transaction{Proposal: tampered.Bytes(), Endorsement: tx.Endorsement}) {
+		panic("endorsement verified for a tampered amount")
+	}
+	invalid := *decoded
+	invalid.PayeeIFSC = "hdfc1234"
+	if _, err := DecodeUPIPayment(invalid.Bytes()); err == nil {
+		panic("decoded a payment with an invalid IFSC")
+	}
+	fmt.Printf("upi: %v, %d bytes\n", decoded, len(encoded))
+}
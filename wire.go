@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Dependency free transport: length-prefixed binary frames over net (optionally crypto/tls).
+//
+//	frame = version (1 byte) | type (1 byte) | payload length (4 bytes, big-endian) | payload
+//
+// Payloads are lists of fields in the canonical encoding of EncodeProposal. Frames longer than
+// MaxFrameSize are rejected before the payload is read so a peer cannot make us allocate arbitrary amounts of memory.
+
+const (
+	WireVersion         = 1
+	wireHeaderSize      = 6
+	DefaultMaxFrameSize = 16 << 20
+)
+
+type FrameType uint8
+
+const (
+	FrameProposal     FrameType = iota + 1 // proposal bytes (client to endorser)
+	FrameEndorsement                       // endorser name, G2Element (endorser to client)
+	FrameTransaction                       // proposal, aggregate endorsement (client to orderer)
+	FrameBlock                             // header fields, orderer signature, aggregate signature, signers, transactions (orderer to peer)
+	FrameAck                               // status, info (reply to a transaction or a refused proposal)
+	FrameDeliver                           // number of the first block wanted (peer to orderer, the connection then only carries blocks)
+	FrameGossipHello                       // name of the sending peer (first frame of a gossip connection)
+	FrameGossipDigest                      // number of blocks the sending peer has (peer to peer)
+)
+
+type AckStatus uint8
+
+const (
+	AckSuccess AckStatus = iota
+	AckBadRequest
+	AckInvalidEndorsement
+	AckRejected // Replayed, expired or for another channel (also sent by endorsers instead of an endorsement)
+)
+
+var (
+	ErrFrameTooLarge   = errors.New("wire: frame exceeds the maximum frame size")
+	ErrWireVersion     = errors.New("wire: unsupported protocol version")
+	ErrUnexpectedFrame = errors.New("wire: unexpected frame type")
+	ErrMalformedFields = errors.New("wire: malformed field encoding")
+)
+
+type WireConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	writeMu      sync.Mutex
+	MaxFrameSize int
+}
+
+func NewWireConn(conn net.Conn) *WireConn {
+	return &WireConn{conn: conn, reader: bufio.NewReader(conn), MaxFrameSize: DefaultMaxFrameSize}
+}
+
+// DialWire connects to addr, over TLS when tlsConfig is not nil
+func DialWire(addr string, tlsConfig *tls.Config) (*WireConn, error) {
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.Dial("tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewWireConn(conn), nil
+}
+
+// ListenWire listens on addr, over TLS when tlsConfig is not nil
+func ListenWire(addr string, tlsConfig *tls.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return listener, nil
+}
+
+func (c *WireConn) Close() error {
+	return c.conn.Close()
+}
+
+// setDeadline applies the deadline of ctx (if any) to the connection
+func (c *WireConn) setDeadline(ctx context.Context) {
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+}
+
+func (c *WireConn) WriteFrame(t FrameType, payload []byte) error {
+	if len(payload) > c.MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, wireHeaderSize, wireHeaderSize+len(payload))
+	frame[0] = WireVersion
+	frame[1] = byte(t)
+	binary.BigEndian.PutUint32(frame[2:], uint32(len(payload)))
+	frame = append(frame, payload...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *WireConn) ReadFrame() (FrameType, []byte, error) {
+	var header [wireHeaderSize]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0] != WireVersion {
+		return 0, nil, ErrWireVersion
+	}
+	length := binary.BigEndian.Uint32(header[2:])
+	if uint64(length) > uint64(c.MaxFrameSize) {
+		return 0, nil, ErrFrameTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	return FrameType(header[1]), payload, nil
+}
+
+// readExpected reads the next frame and fails unless it has type t
+func (c *WireConn) readExpected(t FrameType) ([]byte, error) {
+	got, payload, err := c.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	if got != t {
+		return nil, fmt.Errorf("%w: got %d, expected %d", ErrUnexpectedFrame, got, t)
+	}
+	return payload, nil
+}
+
+// DecodeFields is the inverse of EncodeProposal
+func DecodeFields(data []byte) ([][]byte, error) {
+	var fields [][]byte
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, ErrMalformedFields
+		}
+		length := binary.BigEndian.Uint64(data)
+		data = data[8:]
+		if length > uint64(len(data)) {
+			return nil, ErrMalformedFields
+		}
+		fields = append(fields, data[:length])
+		data = data[length:]
+	}
+	return fields, nil
+}
+
+func EncodeTransaction(tx *Transaction) []byte {
+	return EncodeProposal(tx.Proposal, tx.Endorsement.Serialize())
+}
+
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 2 {
+		return nil, ErrMalformedFields
+	}
+	endorsement, err := ParseBLSBytes[*blschia.G2Element](fields[1])
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{Proposal: fields[0], Endorsement: endorsement}, nil
+}
+
+const blockFixedFields = 7
+
+func EncodeBlock(block *Block) []byte {
+	var number [8]byte
+	var count [4]byte
+	binary.BigEndian.PutUint64(number[:], block.Header.Number)
+	binary.BigEndian.PutUint32(count[:], block.Header.TransactionCount)
+	fields := make([][]byte, 0, blockFixedFields+len(block.Transactions))
+	fields = append(fields, number[:], block.Header.PreviousHash, block.Header.TransactionsRoot, count[:],
+		block.OrdererSignature.Serialize(), block.AggregateSignature.Serialize(), block.Signers)
+	for _, tx := range block.Transactions {
+		fields = append(fields, EncodeTransaction(tx))
+	}
+	return EncodeProposal(fields...)
+}
+
+func DecodeBlock(data []byte) (*Block, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < blockFixedFields || len(fields[0]) != 8 || len(fields[3]) != 4 {
+		return nil, ErrMalformedFields
+	}
+	block := &Block{Header: BlockHeader{
+		Number:           binary.BigEndian.Uint64(fields[0]),
+		PreviousHash:     fields[1],
+		TransactionsRoot: fields[2],
+		TransactionCount: binary.BigEndian.Uint32(fields[3]),
+	}}
+	if len(block.Header.PreviousHash) == 0 {
+		block.Header.PreviousHash = nil
+	}
+	if block.OrdererSignature, err = ParseBLSBytes[*blschia.G2Element](fields[4]); err != nil {
+		return nil, err
+	}
+	if block.AggregateSignature, err = ParseBLSBytes[*blschia.G2Element](fields[5]); err != nil {
+		return nil, err
+	}
+	if len(fields[6]) > 0 {
+		block.Signers = fields[6]
+	}
+	for _, field := range fields[blockFixedFields:] {
+		tx, err := DecodeTransaction(field)
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+	return block, nil
+}
+
+func encodeAck(status AckStatus, info string) []byte {
+	return EncodeProposal([]byte{byte(status)}, []byte(info))
+}
+
+func decodeAck(data []byte) (AckStatus, string, error) {
+	fields, err := DecodeFields(data)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(fields) != 2 || len(fields[0]) != 1 {
+		return 0, "", ErrMalformedFields
+	}
+	return AckStatus(fields[0][0]), string(fields[1]), nil
+}
+
+// ServeWireEndorser answers proposal frames with endorsement frames on every accepted connection, or with a rejecting
+// ack for proposals guard (if not nil) does not accept
+func ServeWireEndorser(listener net.Listener, name string, scheme SignatureScheme, sk *blschia.PrivateKey, guard *ReplayGuard) error {
+	return serveWire(listener, func(conn *WireConn) error {
+		for {
+			proposal, err := conn.readExpected(FrameProposal)
+			if err != nil {
+				return err
+			}
+			if guard != nil {
+				if _, err := guard.Check(proposal, time.Now()); err != nil {
+					if err := conn.WriteFrame(FrameAck, encodeAck(AckRejected, err.Error())); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			sig := scheme.Sign(sk, proposal)
+			if err := conn.WriteFrame(FrameEndorsement, EncodeProposal([]byte(name), sig.Serialize())); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ServeWireOrderer acknowledges transaction frames; a connection that sends a deliver frame is used to stream blocks from then on
+func ServeWireOrderer(listener net.Listener, orderer *Orderer) error {
+	return serveWire(listener, func(conn *WireConn) error {
+		for {
+			t, payload, err := conn.ReadFrame()
+			if err != nil {
+				return err
+			}
+			switch t {
+			case FrameTransaction:
+				status, info := AckSuccess, ""
+				tx, err := DecodeTransaction(payload)
+				if err != nil {
+					status, info = AckBadRequest, err.Error()
+				} else if err := orderer.Submit(tx); errors.Is(err, ErrInvalidEndorsement) {
+					status, info = AckInvalidEndorsement, err.Error()
+				} else if err != nil {
+					status, info = AckRejected, err.Error()
+				}
+				if err := conn.WriteFrame(FrameAck, encodeAck(status, info)); err != nil {
+					return err
+				}
+			case FrameDeliver:
+				if len(payload) != 8 {
+					return ErrMalformedFields
+				}
+				return deliverWire(conn, orderer, binary.BigEndian.Uint64(payload))
+			default:
+				return ErrUnexpectedFrame
+			}
+		}
+	})
+}
+
+func deliverWire(conn *WireConn, orderer *Orderer, next uint64) error {
+	// The peer never writes on a deliver connection again, so a read only returns when it goes away
+	closed := make(chan struct{})
+	go func() {
+		conn.ReadFrame()
+		close(closed)
+	}()
+	for {
+		blocks, update := orderer.Blocks(next)
+		for _, block := range blocks {
+			if err := conn.WriteFrame(FrameBlock, EncodeBlock(block)); err != nil {
+				return err
+			}
+			next++
+		}
+		select {
+		case <-update:
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+func serveWire(listener net.Listener, handle func(*WireConn) error) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			wire := NewWireConn(conn)
+			defer wire.Close()
+			handle(wire)
+		}()
+	}
+}
+
+// Clients implementing the EndorserClient and OrdererClient transport interfaces. Requests on a connection are serialised.
+
+type wireEndorserClient struct {
+	mu   sync.Mutex
+	conn *WireConn
+}
+
+func NewWireEndorserClient(conn *WireConn) EndorserClient {
+	return &wireEndorserClient{conn: conn}
+}
+
+func (c *wireEndorserClient) Endorse(ctx context.Context, proposal []byte) (*blschia.G2Element, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.setDeadline(ctx)
+	if err := c.conn.WriteFrame(FrameProposal, proposal); err != nil {
+		return nil, err
+	}
+	t, payload, err := c.conn.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	if t == FrameAck {
+		status, info, err := decodeAck(payload)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("wire: endorsement refused: status %d %s", status, info)
+	}
+	if t != FrameEndorsement {
+		return nil, fmt.Errorf("%w: got %d, expected %d", ErrUnexpectedFrame, t, FrameEndorsement)
+	}
+	fields, err := DecodeFields(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 2 {
+		return nil, ErrMalformedFields
+	}
+	return ParseBLSBytes[*blschia.G2Element](fields[1])
+}
+
+type wireOrdererClient struct {
+	addr      string
+	tlsConfig *tls.Config
+	mu        sync.Mutex
+	conn      *WireConn
+}
+
+func NewWireOrdererClient(addr string, tlsConfig *tls.Config) (OrdererClient, error) {
+	conn, err := DialWire(addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &wireOrdererClient{addr: addr, tlsConfig: tlsConfig, conn: conn}, nil
+}
+
+func (c *wireOrdererClient) Broadcast(ctx context.Context, tx *Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.setDeadline(ctx)
+	if err := c.conn.WriteFrame(FrameTransaction, EncodeTransaction(tx)); err != nil {
+		return err
+	}
+	payload, err := c.conn.readExpected(FrameAck)
+	if err != nil {
+		return err
+	}
+	status, info, err := decodeAck(payload)
+	if err != nil {
+		return err
+	}
+	if status != AckSuccess {
+		return fmt.Errorf("wire: broadcast rejected: status %d %s", status, info)
+	}
+	return nil
+}
+
+type wireBlockStream struct {
+	conn *WireConn
+}
+
+// Deliver opens a dedicated connection for the block stream, closed once ctx is done
+func (c *wireOrdererClient) Deliver(ctx context.Context, start uint64) (BlockStream, error) {
+	conn, err := DialWire(c.addr, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteFrame(FrameDeliver, binary.BigEndian.AppendUint64(nil, start)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	return &wireBlockStream{conn: conn}, nil
+}
+
+func (s *wireBlockStream) Recv() (*Block, error) {
+	payload, err := s.conn.readExpected(FrameBlock)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeBlock(payload)
+}
+
+// selfSignedTLS returns server and client TLS configurations around a fresh self-signed certificate for 127.0.0.1 (for loopback experiments)
+func selfSignedTLS() (*tls.Config, *tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}}, MinVersion: tls.VersionTLS13}
+	client := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13}
+	return server, client, nil
+}
+
+// Endorsers, orderer and a peer talking the framed protocol over TLS on loopback sockets
+func WireExample() {
+	scheme := blschia.NewPopSchemeMPL()
+	config, sks, orderer_sk := exampleChannel(scheme)
+	serverTLS, clientTLS, err := selfSignedTLS()
+	if err != nil {
+		panic(err)
+	}
+
+	endorsers := make(map[string]EndorserClient)
+	for _, name := range config.Endorsers {
+		listener, err := ListenWire("127.0.0.1:0", serverTLS)
+		if err != nil {
+			panic(err)
+		}
+		defer listener.Close()
+		go ServeWireEndorser(listener, name, scheme, sks[name], nil)
+		conn, err := DialWire(listener.Addr().String(), clientTLS)
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+		endorsers[name] = NewWireEndorserClient(conn)
+	}
+	listener, err := ListenWire("127.0.0.1:0", serverTLS)
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	go ServeWireOrderer(listener, NewOrderer(scheme, config, orderer_sk, OrdererConfig{MaxTransactions: 2, BatchTimeout: 100 * time.Millisecond}))
+	orderer, err := NewWireOrdererClient(listener.Addr().String(), clientTLS)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 0; i < 4; i++ {
+		proposal, _ := makeRandomArray(5000)
+		if err := EndorseAndBroadcast(ctx, scheme, config, endorsers, orderer, proposal); err != nil {
+			panic(err)
+		}
+	}
+	committed := 0
+	if err := DeliverBlocks(ctx, scheme, config, orderer, 0, 2, func(block *Block) { committed += len(block.Transactions) }); err != nil {
+		panic(err)
+	}
+	if committed != 4 {
+		panic("peer did not commit every transaction")
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dashpay/bls-signatures/go-bindings"
+)
+
+// Synthetic UPI workloads and trace replay. A Workload generates payments between accounts of the configured banks with
+// Zipf distributed account popularity (a few merchants and heavy users take most of the traffic) and Poisson arrivals
+// that switch into bursts of a higher rate, like the spikes around salary days and sales. A trace is the same stream
+// of timed payments read from or written to a CSV or JSONL transaction log, and either can be fed to RunSimulation
+// (SimulationConfig.Payments) or to the client node (-trace) so throughput is measured on realistic traffic shapes.
+
+// TimedPayment is a payment submitted At after the start of the workload
+type TimedPayment struct {
+	At      time.Duration
+	Payment *UPIPayment
+}
+
+type WorkloadConfig struct {
+	Banks         []UPIBank
+	BankWeights   []float64 // Relative share of accounts at each bank, uniform if nil
+	Accounts      int       // Accounts per bank
+	Zipf          float64   // Exponent of account popularity (> 1), uniform popularity if 0
+	Rate          float64   // Mean payments per second outside bursts
+	BurstFactor   float64   // Rate multiplier during a burst, no bursts if <= 1
+	BurstLength   time.Duration
+	BurstInterval time.Duration // Mean time between bursts (both are exponentially distributed)
+	MinAmount     uint64        // Paise, amounts are log-uniform between the two
+	MaxAmount     uint64
+	Policy        string
+	Start         time.Time // Timestamp of the start of the workload
+	Seed          int64     // The same seed generates the same payments
+}
+
+var DefaultWorkloadConfig = WorkloadConfig{
+	Banks:         exampleBanks,
+	BankWeights:   []float64{0.35, 0.25, 0.2, 0.12, 0.08},
+	Accounts:      10000,
+	Zipf:          1.2,
+	Rate:          100,
+	BurstFactor:   5,
+	BurstLength:   200 * time.Millisecond,
+	BurstInterval: time.Second,
+	MinAmount:     10_00,
+	MaxAmount:     50000_00,
+	Policy:        "upi-p2p/all-of:NPCI,RBI,SBI,HDFC",
+	Start:         time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC),
+}
+
+var (
+	ErrWorkloadConfig = errors.New("workload: invalid configuration")
+	ErrTraceFormat    = errors.New("trace: unknown format (expected .csv or .jsonl)")
+)
+
+// Workload is a deterministic generator of timed payments (not safe for concurrent use)
+type Workload struct {
+	cfg      WorkloadConfig
+	rng      *rand.Rand
+	zipf     *rand.Zipf
+	weights  []float64 // Cumulative
+	at       time.Duration
+	burst    bool
+	switchAt time.Duration // When the process enters or leaves the next burst
+}
+
+func NewWorkload(cfg WorkloadConfig) (*Workload, error) {
+	switch {
+	case len(cfg.Banks) == 0 || cfg.Accounts <= 0 || len(cfg.Banks)*cfg.Accounts < 2:
+		return nil, fmt.Errorf("%w: need at least two accounts", ErrWorkloadConfig)
+	case cfg.BankWeights != nil && len(cfg.BankWeights) != len(cfg.Banks):
+		return nil, fmt.Errorf("%w: %d weights for %d banks", ErrWorkloadConfig, len(cfg.BankWeights), len(cfg.Banks))
+	case cfg.Zipf != 0 && cfg.Zipf <= 1:
+		return nil, fmt.Errorf("%w: Zipf exponent %v", ErrWorkloadConfig, cfg.Zipf)
+	case cfg.Rate <= 0:
+		return nil, fmt.Errorf("%w: rate %v", ErrWorkloadConfig, cfg.Rate)
+	case cfg.BurstFactor > 1 && (cfg.BurstLength <= 0 || cfg.BurstInterval <= 0):
+		return nil, fmt.Errorf("%w: bursts need a length and an interval", ErrWorkloadConfig)
+	case cfg.MinAmount == 0 || cfg.MinAmount > cfg.MaxAmount || cfg.MaxAmount > MaxUPIAmount:
+		return nil, fmt.Errorf("%w: amounts %d to %d paise", ErrWorkloadConfig, cfg.MinAmount, cfg.MaxAmount)
+	}
+	w := &Workload{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed)), weights: make([]float64, len(cfg.Banks))}
+	total := 0.0
+	positive := 0
+	for i := range cfg.Banks {
+		weight := 1.0
+		if cfg.BankWeights != nil {
+			weight = cfg.BankWeights[i]
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("%w: negative weight for %s", ErrWorkloadConfig, cfg.Banks[i].Name)
+		}
+		if weight > 0 {
+			positive++
+		}
+		total += weight
+		w.weights[i] = total
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: every bank weight is zero", ErrWorkloadConfig)
+	}
+	// Accounts at banks without weight are never drawn, and Next needs two distinct ones
+	if positive*cfg.Accounts < 2 {
+		return nil, fmt.Errorf("%w: need at least two accounts at banks with a positive weight", ErrWorkloadConfig)
+	}
+	if cfg.Zipf != 0 {
+		w.zipf = rand.NewZipf(w.rng, cfg.Zipf, 1, uint64(cfg.Accounts-1))
+	}
+	if cfg.BurstFactor > 1 {
+		w.switchAt = w.exponential(cfg.BurstInterval)
+	}
+	return w, nil
+}
+
+func (w *Workload) exponential(mean time.Duration) time.Duration {
+	return time.Duration(w.rng.ExpFloat64() * float64(mean))
+}
+
+// account draws a bank by weight and an account at it by popularity
+func (w *Workload) account() (string, string) {
+	// The first bank whose cumulative weight is above the draw, which skips banks without weight
+	x := w.rng.Float64() * w.weights[len(w.weights)-1]
+	bank := sort.Search(len(w.weights), func(i int) bool { return w.weights[i] > x })
+	if bank == len(w.weights) {
+		bank--
+	}
+	n := w.rng.Intn(w.cfg.Accounts)
+	if w.zipf != nil {
+		n = int(w.zipf.Uint64())
+	}
+	return bankAccount(w.cfg.Banks[bank], n+1)
+}
+
+// arrival advances to the next arrival of the on/off modulated Poisson process
+func (w *Workload) arrival() {
+	for {
+		rate := w.cfg.Rate
+		if w.burst {
+			rate *= w.cfg.BurstFactor
+		}
+		next := w.at + time.Duration(w.rng.ExpFloat64()/rate*float64(time.Second))
+		if w.cfg.BurstFactor <= 1 || next < w.switchAt {
+			w.at = next
+			return
+		}
+		// Arrivals are memoryless so the wait can be redrawn at the new rate
+		w.at = w.switchAt
+		w.burst = !w.burst
+		if w.burst {
+			w.switchAt += w.exponential(w.cfg.BurstLength)
+		} else {
+			w.switchAt += w.exponential(w.cfg.BurstInterval)
+		}
+	}
+}
+
+func (w *Workload) Next() TimedPayment {
+	w.arrival()
+	payerVPA, payerIFSC := w.account()
+	payeeVPA, payeeIFSC := w.account()
+	for payeeVPA == payerVPA {
+		payeeVPA, payeeIFSC = w.account()
+	}
+	low, high := math.Log(float64(w.cfg.MinAmount)), math.Log(float64(w.cfg.MaxAmount))
+	amount := uint64(math.Exp(low + w.rng.Float64()*(high-low)))
+	amount = min(max(amount, w.cfg.MinAmount), w.cfg.MaxAmount)
+	// Timed to the millisecond like the timestamps so that a saved trace replays the same
+	at := w.at.Truncate(time.Millisecond)
+	p := &UPIPayment{PayerVPA: payerVPA, PayerIFSC: payerIFSC, PayeeVPA: payeeVPA, PayeeIFSC: payeeIFSC, Amount: amount,
+		ReferenceID: fmt.Sprintf("%012d", w.rng.Int63n(1e12)), Timestamp: w.cfg.Start.Add(at).UTC(), Policy: w.cfg.Policy}
+	w.rng.Read(p.Nonce[:])
+	return TimedPayment{At: at, Payment: p}
+}
+
+// Generate returns the next n payments
+func (w *Workload) Generate(n int) []TimedPayment {
+	payments := make([]TimedPayment, n)
+	for i := range payments {
+		payments[i] = w.Next()
+	}
+	return payments
+}
+
+// traceRecord is a line of a transaction log. The nonce is optional as logs from other systems have none.
+type traceRecord struct {
+	Timestamp string `json:"timestamp"` // RFC 3339
+	PayerVPA  string `json:"payer_vpa"`
+	PayerIFSC string `json:"payer_ifsc"`
+	PayeeVPA  string `json:"payee_vpa"`
+	PayeeIFSC string `json:"payee_ifsc"`
+	Amount    uint64 `json:"amount"` // Paise
+	Reference string `json:"reference_id"`
+	Nonce     string `json:"nonce,omitempty"` // Hex
+	Policy    string `json:"policy"`
+}
+
+var traceColumns = []string{"timestamp", "payer_vpa", "payer_ifsc", "payee_vpa", "payee_ifsc", "amount", "reference_id", "nonce", "policy"}
+
+func newTraceRecord(p *UPIPayment) traceRecord {
+	return traceRecord{Timestamp: p.Timestamp.UTC().Format(time.RFC3339Nano), PayerVPA: p.PayerVPA, PayerIFSC: p.PayerIFSC,
+		PayeeVPA: p.PayeeVPA, PayeeIFSC: p.PayeeIFSC, Amount: p.Amount, Reference: p.ReferenceID, Nonce: hex.EncodeToString(p.Nonce[:]),
+		Policy: p.Policy}
+}
+
+func (r traceRecord) payment() (*UPIPayment, error) {
+	timestamp, err := time.Parse(time.RFC3339Nano, r.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	p := &UPIPayment{PayerVPA: r.PayerVPA, PayerIFSC: r.PayerIFSC, PayeeVPA: r.PayeeVPA, PayeeIFSC: r.PayeeIFSC, Amount: r.Amount,
+		ReferenceID: r.Reference, Timestamp: timestamp.UTC().Truncate(time.Millisecond), Policy: r.Policy}
+	if r.Nonce == "" {
+		nonce, _ := makeRandomArray(upiNonceSize)
+		copy(p.Nonce[:], nonce)
+	} else if nonce, err := hex.DecodeString(r.Nonce); err != nil || len(nonce) != upiNonceSize {
+		return nil, fmt.Errorf("invalid nonce %q", r.Nonce)
+	} else {
+		copy(p.Nonce[:], nonce)
+	}
+	return p, p.Validate()
+}
+
+func (r traceRecord) fields() []string {
+	return []string{r.Timestamp, r.PayerVPA, r.PayerIFSC, r.PayeeVPA, r.PayeeIFSC, strconv.FormatUint(r.Amount, 10), r.Reference, r.Nonce, r.Policy}
+}
+
+// traceFormat is "csv" or "jsonl" by the file extension
+func traceFormat(path string) (string, error) {
+	switch filepath.Ext(path) {
+	case ".csv":
+		return "csv", nil
+	case ".jsonl":
+		return "jsonl", nil
+	}
+	return "", ErrTraceFormat
+}
+
+// ReadTrace reads a transaction log with a header row (csv) or one JSON object per line (jsonl). Payments are ordered
+// by timestamp and timed relative to the first.
+func ReadTrace(r io.Reader, format string) ([]TimedPayment, error) {
+	var records []traceRecord
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(traceColumns)
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("trace: %w", err)
+		}
+		for i, column := range traceColumns {
+			if header[i] != column {
+				return nil, fmt.Errorf("trace: column %d is %q instead of %q", i+1, header[i], column)
+			}
+		}
+		for {
+			fields, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("trace: %w", err)
+			}
+			amount, err := strconv.ParseUint(fields[5], 10, 64)
+			if err != nil {
+				line, _ := reader.FieldPos(5)
+				return nil, fmt.Errorf("trace: line %d: %w", line, err)
+			}
+			records = append(records, traceRecord{fields[0], fields[1], fields[2], fields[3], fields[4], amount, fields[6], fields[7], fields[8]})
+		}
+	case "jsonl":
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var record traceRecord
+			decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&record); err != nil {
+				return nil, fmt.Errorf("trace: line %d: %w", line, err)
+			}
+			records = append(records, record)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("trace: %w", err)
+		}
+	default:
+		return nil, ErrTraceFormat
+	}
+
+	payments := make([]TimedPayment, len(records))
+	for i, record := range records {
+		p, err := record.payment()
+		if err != nil {
+			return nil, fmt.Errorf("trace: record %d: %w", i+1, err)
+		}
+		payments[i].Payment = p
+	}
+	sort.SliceStable(payments, func(i, j int) bool { return payments[i].Payment.Timestamp.Before(payments[j].Payment.Timestamp) })
+	for i := range payments {
+		payments[i].At = payments[i].Payment.Timestamp.Sub(payments[0].Payment.Timestamp)
+	}
+	return payments, nil
+}
+
+func WriteTrace(w io.Writer, format string, payments []TimedPayment) error {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write(traceColumns)
+		for _, tp := range payments {
+			writer.Write(newTraceRecord(tp.Payment).fields())
+		}
+		writer.Flush()
+		return writer.Error()
+	case "jsonl":
+		encoder := json.NewEncoder(w)
+		for _, tp := range payments {
+			if err := encoder.Encode(newTraceRecord(tp.Payment)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return ErrTraceFormat
+}
+
+func LoadTrace(path string) ([]TimedPayment, error) {
+	format, err := traceFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadTrace(file, format)
+}
+
+func SaveTrace(path string, payments []TimedPayment) error {
+	format, err := traceFormat(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteTrace(file, format, payments); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// SpeedUp replays a trace speedup times faster than it was recorded
+func SpeedUp(payments []TimedPayment, speedup float64) []TimedPayment {
+	scaled := make([]TimedPayment, len(payments))
+	for i, tp := range payments {
+		scaled[i] = TimedPayment{At: time.Duration(float64(tp.At) / speedup), Payment: tp.Payment}
+	}
+	return scaled
+}
+
+// Generating a bursty workload, round tripping it through both trace formats and replaying it through the simulated
+// endorse-order-commit pipeline against a constant rate of the same mean
+func WorkloadExample() {
+	cfg := DefaultWorkloadConfig
+	cfg.Rate = 200
+	workload, err := NewWorkload(cfg)
+	if err != nil {
+		panic(err)
+	}
+	payments := workload.Generate(60)
+	// A payer and a payee are only drawn among the accounts of banks with weight
+	lopsided := cfg
+	lopsided.Accounts, lopsided.BankWeights = 1, make([]float64, len(cfg.Banks))
+	lopsided.BankWeights[0] = 1
+	if _, err := NewWorkload(lopsided); !errors.Is(err, ErrWorkloadConfig) {
+		panic(fmt.Sprintf("accepted a workload with a single account to draw from: %v", err))
+	}
+
+	dir, err := os.MkdirTemp("", "chia-trace")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"trace.csv", "trace.jsonl"} {
+		path := filepath.Join(dir, name)
+		if err := SaveTrace(path, payments); err != nil {
+			panic(err)
+		}
+		replayed, err := LoadTrace(path)
+		if err != nil {
+			panic(err)
+		}
+		if len(replayed) != len(payments) {
+			panic("trace lost payments")
+		}
+		for i := range replayed {
+			if !bytes.Equal(replayed[i].Payment.Bytes(), payments[i].Payment.Bytes()) || replayed[i].At != payments[i].At-payments[0].At {
+				panic("trace does not round trip")
+			}
+		}
+	}
+
+	banks := make(map[string]int)
+	for _, tp := range payments {
+		banks[tp.Payment.PayerIFSC[:4]]++
+	}
+	duration := payments[len(payments)-1].At
+	fmt.Printf("workload: %d payments over %v, payers by bank %v\n", len(payments), duration.Round(time.Millisecond), banks)
+
+	link := LinkConfig{Latency: 2 * time.Millisecond, Jitter: time.Millisecond, Bandwidth: 100 << 20}
+	scheme := blschia.NewPopSchemeMPL()
+	cuts := OrdererConfig{MaxTransactions: 8, BatchTimeout: 10 * time.Millisecond}
+	bursty := RunSimulation(SimulationConfig{Scheme: scheme, Link: link, Peers: 2, Payments: payments, Cuts: cuts, Timeout: 10 * time.Second})
+	steady := RunSimulation(SimulationConfig{Scheme: scheme, Link: link, Peers: 2, Transactions: len(payments),
+		Rate: float64(len(payments)) / duration.Seconds(), PayloadSize: len(payments[0].Payment.Bytes()), Cuts: cuts, Timeout: 10 * time.Second})
+	fmt.Printf("workload: trace replay %v\n", bursty)
+	fmt.Printf("workload: constant rate %v\n", steady)
+	if bursty.Committed != len(payments) || steady.Committed != len(payments) {
+		panic("simulation did not commit every payment")
+	}
+}